@@ -0,0 +1,225 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// embedded holds the static assets (CSS, JS, images) served under /static
+//
+//go:embed static
+var embedded embed.FS
+
+// FS returns the embedded static assets, rooted at the static directory
+func FS() fs.FS {
+	sub, err := fs.Sub(embedded, "static")
+	if err != nil {
+		// This can only happen if the embed directive above is changed
+		panic(err)
+	}
+	return sub
+}
+
+// fingerprintLen is how many hex characters of the content hash
+// are added to fingerprinted file names
+const fingerprintLen = 12
+
+// Asset is a single static file along with its fingerprinted name
+type Asset struct {
+	// Name is the path of the file in the filesystem, eg. "css/app.css"
+	Name string
+	// Fingerprinted is Name with a content hash added, eg. "css/app.0123456789ab.css"
+	Fingerprinted string
+	// Integrity is the subresource integrity hash of the file, eg. "sha384-..."
+	Integrity string
+
+	content []byte
+	modTime time.Time
+}
+
+// Manifest maps every asset to its fingerprinted name and integrity
+// hash. It is built once at startup by hashing the contents of each
+// file, and again whenever a file changes when Reload is set.
+type Manifest struct {
+	// Reload rebuilds the manifest when a file is added, removed or
+	// modified, so edits show up without a restart. It walks every
+	// file on each lookup and is only meant for development.
+	Reload bool
+
+	prefix string
+	fsys   fs.FS
+
+	// mu guards the maps below while they are rebuilt. They are
+	// replaced rather than changed, so they can be used unlocked.
+	mu              sync.RWMutex
+	byName          map[string]*Asset
+	byFingerprinted map[string]*Asset
+	modTimes        map[string]time.Time
+}
+
+// NewManifest walks fsys and builds a manifest of every file in it.
+// The assets will be served under prefix, eg. "/static/".
+func NewManifest(fsys fs.FS, prefix string) (*Manifest, error) {
+	m := &Manifest{
+		prefix: prefix,
+		fsys:   fsys,
+	}
+	if err := m.build(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// build hashes every file of the manifest. m.mu must be held.
+func (m *Manifest) build() error {
+	byName := make(map[string]*Asset)
+	byFingerprinted := make(map[string]*Asset)
+	modTimes := make(map[string]time.Time)
+	err := fs.WalkDir(m.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(m.fsys, name)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		sri := sha512.Sum384(content)
+		asset := &Asset{
+			Name:          name,
+			Fingerprinted: fingerprint(name, hex.EncodeToString(sum[:])[:fingerprintLen]),
+			Integrity:     "sha384-" + base64.StdEncoding.EncodeToString(sri[:]),
+			content:       content,
+			modTime:       info.ModTime(),
+		}
+		byName[asset.Name] = asset
+		byFingerprinted[asset.Fingerprinted] = asset
+		modTimes[asset.Name] = asset.modTime
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.byName, m.byFingerprinted, m.modTimes = byName, byFingerprinted, modTimes
+	return nil
+}
+
+// changed reports whether a file was added, removed or modified since
+// the manifest was built. m.mu must be held for reading.
+func (m *Manifest) changed() bool {
+	seen := 0
+	err := fs.WalkDir(m.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if last, ok := m.modTimes[name]; !ok || !info.ModTime().Equal(last) {
+			return errChanged
+		}
+		seen++
+		return nil
+	})
+	return err != nil || seen != len(m.modTimes)
+}
+
+// errChanged stops walking the files once one of them changed
+var errChanged = errors.New("assets: changed")
+
+// assets returns the assets by name and by fingerprinted name,
+// rebuilding them first if Reload is set and a file changed. A
+// failed rebuild is logged and the previous assets are kept.
+func (m *Manifest) assets() (byName, byFingerprinted map[string]*Asset) {
+	if m.Reload {
+		m.mu.RLock()
+		changed := m.changed()
+		m.mu.RUnlock()
+		if changed {
+			m.mu.Lock()
+			if m.changed() {
+				if err := m.build(); err != nil {
+					log.Printf("assets: %v", err)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.byName, m.byFingerprinted
+}
+
+// Lookup returns the asset with the provided (unfingerprinted) name
+func (m *Manifest) Lookup(name string) (*Asset, bool) {
+	byName, _ := m.assets()
+	asset, ok := byName[strings.TrimPrefix(name, "/")]
+	return asset, ok
+}
+
+// URL returns the fingerprinted URL of the asset with the provided name
+func (m *Manifest) URL(name string) (string, error) {
+	asset, ok := m.Lookup(name)
+	if !ok {
+		return "", fmt.Errorf("assets: %q is not in the manifest", name)
+	}
+	return m.prefix + asset.Fingerprinted, nil
+}
+
+// Integrity returns the subresource integrity hash of the asset with
+// the provided name, to be used in an integrity="..." attribute
+func (m *Manifest) Integrity(name string) (string, error) {
+	asset, ok := m.Lookup(name)
+	if !ok {
+		return "", fmt.Errorf("assets: %q is not in the manifest", name)
+	}
+	return asset.Integrity, nil
+}
+
+// Handler serves the assets in the manifest under its prefix.
+// Fingerprinted names can never change content, so they are cached for
+// a year. Plain names are still served but must be revalidated.
+func (m *Manifest) Handler() http.Handler {
+	return http.StripPrefix(m.prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		byName, byFingerprinted := m.assets()
+		if asset, ok := byFingerprinted[r.URL.Path]; ok {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			w.Header().Set("ETag", `"`+asset.Fingerprinted+`"`)
+			http.ServeContent(w, r, asset.Name, asset.modTime, bytes.NewReader(asset.content))
+			return
+		}
+		if asset, ok := byName[r.URL.Path]; ok {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"`+asset.Fingerprinted+`"`)
+			http.ServeContent(w, r, asset.Name, asset.modTime, bytes.NewReader(asset.content))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+// fingerprint adds hash to name right before its extension
+//
+// Eg. fingerprint("css/app.css", "abc") would result in "css/app.abc.css"
+func fingerprint(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
@@ -0,0 +1,87 @@
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestManifestHandler(t *testing.T) {
+	m, err := NewManifest(fstest.MapFS{
+		"css/app.css": {Data: []byte("body {}")},
+	}, "/static/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset, ok := m.Lookup("css/app.css")
+	if !ok {
+		t.Fatal("Expected css/app.css to be in the manifest")
+	}
+	if asset.Fingerprinted == asset.Name {
+		t.Fatalf("Expected a fingerprinted name, got %s", asset.Fingerprinted)
+	}
+
+	url, err := m.URL("css/app.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "/static/"+asset.Fingerprinted {
+		t.Fatalf("Expected URL /static/%s, got %s", asset.Fingerprinted, url)
+	}
+
+	h := m.Handler()
+	cases := []struct {
+		path         string
+		status       int
+		cacheControl string
+	}{
+		{"/static/" + asset.Fingerprinted, http.StatusOK, "public, max-age=31536000, immutable"},
+		{"/static/css/app.css", http.StatusOK, "no-cache"},
+		{"/static/css/missing.css", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.status {
+			t.Errorf("GET %s: expected status %d, got %d", c.path, c.status, w.Code)
+		}
+		if got := w.Header().Get("Cache-Control"); got != c.cacheControl {
+			t.Errorf("GET %s: expected Cache-Control %q, got %q", c.path, c.cacheControl, got)
+		}
+	}
+}
+
+func TestManifestReload(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/app.css": {Data: []byte("body {}"), ModTime: time.Unix(1, 0)},
+	}
+	m, err := NewManifest(fsys, "/static/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Reload = true
+	before, err := m.URL("css/app.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys["css/app.css"] = &fstest.MapFile{Data: []byte("body { color: red }"), ModTime: time.Unix(2, 0)}
+	fsys["js/app.js"] = &fstest.MapFile{Data: []byte("// app"), ModTime: time.Unix(2, 0)}
+	after, err := m.URL("css/app.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == before {
+		t.Errorf("Expected a new URL after the file changed, got %s", after)
+	}
+	if _, ok := m.Lookup("js/app.js"); !ok {
+		t.Error("Expected the added js/app.js to be in the manifest")
+	}
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", after, nil))
+	if got := w.Body.String(); got != "body { color: red }" {
+		t.Errorf("GET %s: expected the new content, got %q", after, got)
+	}
+}
@@ -0,0 +1,13 @@
+// Site wide scripts, loaded after Bootstrap
+(function () {
+    "use strict";
+
+    // Ask for confirmation before submitting destructive forms, eg.
+    // <form data-confirm="Are you sure?">
+    document.addEventListener("submit", function (event) {
+        var message = event.target.getAttribute("data-confirm");
+        if (message && !window.confirm(message)) {
+            event.preventDefault();
+        }
+    });
+})();
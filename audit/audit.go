@@ -0,0 +1,192 @@
+// Package audit keeps an append-only trail of security relevant
+// events, eg. an admin disabling a user, recording who did what to
+// whom, from where and as part of which request.
+package audit
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+)
+
+// Actions users perform on their own account, or the site performs
+// on their behalf
+const (
+	ActionLogin          = "user.login"
+	ActionLoginFailed    = "user.login_failed"
+	ActionLogout         = "user.logout"
+	ActionSignup         = "user.signup"
+	ActionPasswordChange = "user.password_change"
+	ActionEmailChange    = "user.email_change"
+	ActionDelete         = "user.delete"
+	ActionPurge          = "user.purge"
+	ActionRestore        = "user.restore"
+	ActionExport         = "user.export"
+	ActionTokenCreate    = "user.token_create"
+	ActionTokenRevoke    = "user.token_revoke"
+	ActionIdentityLink   = "user.identity_link"
+	ActionIdentityUnlink = "user.identity_unlink"
+)
+
+// Actions admins perform on other users and roles
+const (
+	ActionUserDisable       = "admin.user.disable"
+	ActionUserEnable        = "admin.user.enable"
+	ActionUserPasswordReset = "admin.user.password_reset"
+	ActionUserSignOut       = "admin.user.sign_out"
+	ActionUserRoleAssign    = "admin.user.role_assign"
+	ActionUserRoleUnassign  = "admin.user.role_unassign"
+	ActionRoleCreate        = "admin.role.create"
+	ActionRoleUpdate        = "admin.role.update"
+	ActionRoleDelete        = "admin.role.delete"
+)
+
+// Actions lists every action, eg. to filter the audit trail by
+var Actions = []string{
+	ActionLogin, ActionLoginFailed, ActionLogout, ActionSignup,
+	ActionPasswordChange, ActionEmailChange,
+	ActionDelete, ActionRestore, ActionPurge, ActionExport,
+	ActionTokenCreate, ActionTokenRevoke,
+	ActionIdentityLink, ActionIdentityUnlink,
+	ActionUserDisable, ActionUserEnable,
+	ActionUserPasswordReset, ActionUserSignOut,
+	ActionUserRoleAssign, ActionUserRoleUnassign,
+	ActionRoleCreate, ActionRoleUpdate, ActionRoleDelete,
+}
+
+// Target types of events
+const (
+	TargetUser     = "user"
+	TargetRole     = "role"
+	TargetToken    = "token"
+	TargetIdentity = "identity"
+)
+
+// Event is a single entry in the audit trail. Events are never
+// updated or deleted once they are appended.
+type Event struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+
+	// ActorID is the ID of the user who performed the action,
+	// 0 when it was not performed by a signed in user
+	ActorID uint   `gorm:"index"`
+	Action  string `gorm:"not null;index"`
+
+	// TargetType and TargetID identify what the action was
+	// performed on, eg. "user" and the ID of the user
+	TargetType string
+	TargetID   uint `gorm:"index"`
+
+	// Details adds context to some actions, eg. the email address
+	// a failed login was attempted with
+	Details string
+
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// TableName keeps the table name short and stable
+func (Event) TableName() string {
+	return "audit_events"
+}
+
+// NewEvent returns an event for action performed by the user with
+// actorID, filling in where the request came from
+func NewEvent(r *http.Request, actorID uint, action string) *Event {
+	return &Event{
+		ActorID:   actorID,
+		Action:    action,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: logging.RequestID(r.Context()),
+	}
+}
+
+// Target sets what the event was performed on and returns e, so it
+// can be chained with NewEvent
+func (e *Event) Target(typ string, id uint) *Event {
+	e.TargetType = typ
+	e.TargetID = id
+	return e
+}
+
+// clientIP returns the IP address of the client without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Filter limits the events returned by Store.List. Zero values
+// match every event.
+type Filter struct {
+	ActorID    uint
+	TargetType string
+	TargetID   uint
+
+	// UserID matches events performed by or on the user with the ID
+	UserID uint
+
+	// Action matches events with exactly this action or, when it
+	// ends with a ".", every action starting with it, eg. "admin."
+	Action string
+
+	// Since and Until only match events created in that time range
+	Since time.Time
+	Until time.Time
+
+	// BeforeID only matches events older than the event with that ID,
+	// the ID of the last event of a page gives the next page
+	BeforeID uint
+
+	// Limit is the maximum number of events returned, newest first
+	Limit int
+}
+
+// matches reports whether e passes the filter
+func (f Filter) matches(e *Event) bool {
+	switch {
+	case f.ActorID != 0 && e.ActorID != f.ActorID:
+		return false
+	case f.TargetType != "" && e.TargetType != f.TargetType:
+		return false
+	case f.TargetID != 0 && e.TargetID != f.TargetID:
+		return false
+	case f.UserID != 0 && e.ActorID != f.UserID && !(e.TargetType == TargetUser && e.TargetID == f.UserID):
+		return false
+	case f.Action != "" && !f.matchesAction(e.Action):
+		return false
+	case !f.Since.IsZero() && e.CreatedAt.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.CreatedAt.Before(f.Until):
+		return false
+	case f.BeforeID != 0 && e.ID >= f.BeforeID:
+		return false
+	}
+	return true
+}
+
+func (f Filter) matchesAction(action string) bool {
+	if strings.HasSuffix(f.Action, ".") {
+		return strings.HasPrefix(action, f.Action)
+	}
+	return action == f.Action
+}
+
+// DefaultLimit is the number of events List returns when the filter
+// does not set a Limit
+const DefaultLimit = 50
+
+// Store is where events are kept. There are deliberately no methods
+// to change or remove events.
+type Store interface {
+	Append(e *Event) error
+	List(f Filter) ([]Event, error)
+}
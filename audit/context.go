@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+)
+
+type privateKey string
+
+const originKey privateKey = "origin"
+
+// origin is who a request was made by and where it came from
+type origin struct {
+	actorID   uint
+	ip        string
+	userAgent string
+}
+
+// WithRequest returns a copy of ctx that remembers where r came
+// from, so events can be created without the request at hand, eg.
+// by the services of the models package
+func WithRequest(ctx context.Context, r *http.Request) context.Context {
+	o := fromContext(ctx)
+	o.ip = clientIP(r)
+	o.userAgent = r.UserAgent()
+	return context.WithValue(ctx, originKey, o)
+}
+
+// WithActor returns a copy of ctx whose events are performed by the
+// user with actorID
+func WithActor(ctx context.Context, actorID uint) context.Context {
+	o := fromContext(ctx)
+	o.actorID = actorID
+	return context.WithValue(ctx, originKey, o)
+}
+
+// Actor returns the ID of the user ctx belongs to, 0 if there is no
+// signed in user
+func Actor(ctx context.Context) uint {
+	return fromContext(ctx).actorID
+}
+
+func fromContext(ctx context.Context) origin {
+	o, _ := ctx.Value(originKey).(origin)
+	return o
+}
+
+// NewContextEvent returns an event for action performed by the actor
+// of ctx, filling in where the request came from like NewEvent
+func NewContextEvent(ctx context.Context, action string) *Event {
+	o := fromContext(ctx)
+	return &Event{
+		ActorID:   o.actorID,
+		Action:    action,
+		IP:        o.ip,
+		UserAgent: o.userAgent,
+		RequestID: logging.RequestID(ctx),
+	}
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+var _ Store = &gormStore{}
+
+// NewGormStore returns a Store keeping events in the audit_events
+// table of db
+func NewGormStore(db *gorm.DB) Store {
+	return &gormStore{
+		db: db,
+	}
+}
+
+type gormStore struct {
+	db *gorm.DB
+}
+
+// Append stores e, filling in its ID and CreatedAt
+func (s *gormStore) Append(e *Event) error {
+	return s.db.Create(e).Error
+}
+
+// List returns the events matching f, newest first
+func (s *gormStore) List(f Filter) ([]Event, error) {
+	db := s.db
+	if f.ActorID != 0 {
+		db = db.Where("actor_id = ?", f.ActorID)
+	}
+	if f.TargetType != "" {
+		db = db.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != 0 {
+		db = db.Where("target_id = ?", f.TargetID)
+	}
+	if f.UserID != 0 {
+		db = db.Where("actor_id = ? OR (target_type = ? AND target_id = ?)", f.UserID, TargetUser, f.UserID)
+	}
+	if strings.HasSuffix(f.Action, ".") {
+		db = db.Where("action LIKE ?", strings.NewReplacer("%", `\%`, "_", `\_`).Replace(f.Action)+"%")
+	} else if f.Action != "" {
+		db = db.Where("action = ?", f.Action)
+	}
+	if !f.Since.IsZero() {
+		db = db.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		db = db.Where("created_at < ?", f.Until)
+	}
+	if f.BeforeID != 0 {
+		db = db.Where("id < ?", f.BeforeID)
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	var events []Event
+	// IDs grow with every event, so they order events by time and
+	// let BeforeID page through them
+	err := db.Order("id desc").Limit(f.Limit).Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"sync"
+	"time"
+)
+
+var _ Store = &memoryStore{}
+
+// NewMemoryStore returns a Store keeping events in memory. It is
+// meant for tests and local development, every event is lost when
+// the process exits.
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+type memoryStore struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Append stores a copy of e, filling in its ID and CreatedAt
+func (s *memoryStore) Append(e *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = uint(len(s.events) + 1)
+	e.CreatedAt = time.Now()
+	s.events = append(s.events, *e)
+	return nil
+}
+
+// List returns the events matching f, newest first
+func (s *memoryStore) List(f Filter) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	var events []Event
+	for i := len(s.events) - 1; i >= 0 && len(events) < f.Limit; i-- {
+		if f.matches(&s.events[i]) {
+			events = append(events, s.events[i])
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/oauth"
+)
+
+// These should be pulled in as environment, but just for testing...
+// They are used when neither -db nor $DATABASE_URL is set.
+const (
+	host = "localhost"
+	port = 5432
+	// Personal Laptop
+	// user     = "vinnysabatini"
+	// password = "notrealpassword"
+	// dbname   = "vinnysabatini"
+	// Work Laptop
+	user     = "postgres"
+	password = ""
+	dbname   = "postgres"
+)
+
+// config is the configuration every command shares, read from the
+// flags given before the command and the environment
+type config struct {
+	Database  string
+	LogFormat logging.Format
+
+	Dev                 bool
+	Addr                string
+	BaseURL             string
+	AdminAddr           string
+	ShutdownDelay       time.Duration
+	ShutdownTimeout     time.Duration
+	MetricsAuth         string
+	CSRFKey             string
+	DeletionGracePeriod time.Duration
+	PurgeInterval       time.Duration
+
+	// Identity providers users can sign in with, each is enabled
+	// when its client ID is set
+	GitHubClientID     string
+	GitHubClientSecret string
+	GoogleClientID     string
+	GoogleClientSecret string
+	OIDCName           string
+	OIDCDisplayName    string
+	OIDCIssuer         string
+	OIDCClientID       string
+	OIDCClientSecret   string
+
+	// Command is the command to run, "serve" when none is given,
+	// and Args the arguments that follow it
+	Command string
+	Args    []string
+}
+
+// loadConfig parses the flags of args, eg. os.Args[1:], into a config
+func loadConfig(args []string) (*config, error) {
+	defaultDB := os.Getenv("DATABASE_URL")
+	if defaultDB == "" {
+		defaultDB = fmt.Sprintf("host=%s port=%d password=%s user=%s dbname=%s sslmode=disable", host, port, password, user, dbname)
+	}
+
+	cfg := &config{}
+	fs := flag.NewFlagSet("web-dev-with-go", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.Database, "db", defaultDB, "Postgres connection string (defaults to $DATABASE_URL)")
+	logFormat := fs.String("log-format", "logfmt", "Format of the logs, either json or logfmt")
+	fs.BoolVar(&cfg.Dev, "dev", false, "Run in development mode, reloading templates when they change")
+	fs.StringVar(&cfg.Addr, "addr", ":3000", "Address the server listens on")
+	fs.StringVar(&cfg.BaseURL, "base-url", "http://localhost:3000", "Public URL of the site, used for canonical links")
+	fs.StringVar(&cfg.AdminAddr, "admin-addr", "", "Serve /metrics on this address, eg. localhost:9090, instead of the main server")
+	fs.DurationVar(&cfg.ShutdownDelay, "shutdown-delay", 0, "How long to report not ready before shutting down, so load balancers stop sending traffic")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for open requests when shutting down")
+	fs.StringVar(&cfg.MetricsAuth, "metrics-auth", os.Getenv("METRICS_AUTH"), "Protect /metrics with basic auth, given as user:password (defaults to $METRICS_AUTH)")
+	fs.StringVar(&cfg.CSRFKey, "csrf-key", os.Getenv("CSRF_KEY"), "32 byte key signing CSRF tokens, random when empty (defaults to $CSRF_KEY)")
+	fs.DurationVar(&cfg.DeletionGracePeriod, "deletion-grace-period", 30*24*time.Hour, "How long deleted accounts are kept before they are purged for good")
+	fs.DurationVar(&cfg.PurgeInterval, "purge-interval", time.Hour, "How often to purge the accounts deleted longer than -deletion-grace-period ago")
+	fs.StringVar(&cfg.GitHubClientID, "github-client-id", os.Getenv("GITHUB_CLIENT_ID"), "Client ID of the GitHub OAuth app to sign in with (defaults to $GITHUB_CLIENT_ID)")
+	fs.StringVar(&cfg.GitHubClientSecret, "github-client-secret", os.Getenv("GITHUB_CLIENT_SECRET"), "Client secret of the GitHub OAuth app (defaults to $GITHUB_CLIENT_SECRET)")
+	fs.StringVar(&cfg.GoogleClientID, "google-client-id", os.Getenv("GOOGLE_CLIENT_ID"), "Client ID of the Google OAuth client to sign in with (defaults to $GOOGLE_CLIENT_ID)")
+	fs.StringVar(&cfg.GoogleClientSecret, "google-client-secret", os.Getenv("GOOGLE_CLIENT_SECRET"), "Client secret of the Google OAuth client (defaults to $GOOGLE_CLIENT_SECRET)")
+	fs.StringVar(&cfg.OIDCIssuer, "oidc-issuer", os.Getenv("OIDC_ISSUER"), "Issuer URL of another OpenID Connect provider to sign in with (defaults to $OIDC_ISSUER)")
+	fs.StringVar(&cfg.OIDCClientID, "oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "Client ID at the -oidc-issuer (defaults to $OIDC_CLIENT_ID)")
+	fs.StringVar(&cfg.OIDCClientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "Client secret at the -oidc-issuer (defaults to $OIDC_CLIENT_SECRET)")
+	fs.StringVar(&cfg.OIDCName, "oidc-name", "sso", "Name of the -oidc-issuer in URLs and linked accounts, it must not change once users linked it")
+	fs.StringVar(&cfg.OIDCDisplayName, "oidc-display-name", "Single Sign-On", "Name of the -oidc-issuer shown to users")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	format, err := logging.ParseFormat(*logFormat)
+	if err != nil {
+		return nil, err
+	}
+	cfg.LogFormat = format
+	if cfg.MetricsAuth != "" && !strings.Contains(cfg.MetricsAuth, ":") {
+		return nil, fmt.Errorf("metrics auth must be given as user:password")
+	}
+	if cfg.CSRFKey != "" && len(cfg.CSRFKey) != 32 {
+		return nil, fmt.Errorf("csrf key must be 32 bytes long")
+	}
+	if cfg.OIDCIssuer != "" && cfg.OIDCClientID == "" {
+		return nil, fmt.Errorf("-oidc-issuer needs an -oidc-client-id")
+	}
+	if !providerName.MatchString(cfg.OIDCName) {
+		return nil, fmt.Errorf("-oidc-name must be made of lowercase letters, digits, dashes and underscores")
+	}
+	cfg.Command = "serve"
+	if fs.NArg() > 0 {
+		cfg.Command, cfg.Args = fs.Arg(0), fs.Args()[1:]
+	}
+	return cfg, nil
+}
+
+// secure reports whether the site is served over https, so cookies
+// can be limited to it
+func (cfg *config) secure() bool {
+	return strings.HasPrefix(strings.ToLower(cfg.BaseURL), "https://")
+}
+
+// providerName is what names of identity providers are made of,
+// since they are used in URLs
+var providerName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// providers returns the identity providers that have a client ID,
+// looking up the endpoints of the generic OpenID Connect provider
+func (cfg *config) providers(ctx context.Context) ([]*oauth.Provider, error) {
+	var providers []*oauth.Provider
+	if cfg.GitHubClientID != "" {
+		providers = append(providers, oauth.GitHub(cfg.GitHubClientID, cfg.GitHubClientSecret))
+	}
+	if cfg.GoogleClientID != "" {
+		providers = append(providers, oauth.Google(cfg.GoogleClientID, cfg.GoogleClientSecret))
+	}
+	if cfg.OIDCClientID != "" {
+		p := oauth.OIDC(cfg.OIDCName, cfg.OIDCDisplayName, cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret)
+		if err := p.Discover(ctx); err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// openServices connects to the database, bringing the schema up to
+// date first when migrate is set
+func (cfg *config) openServices(migrate bool) (*models.Services, error) {
+	services, err := models.NewServices(cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+	if migrate {
+		if err := services.Migrate(); err != nil {
+			services.Close()
+			return nil, err
+		}
+	}
+	return services, nil
+}
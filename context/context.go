@@ -0,0 +1,73 @@
+package context
+
+import (
+	"context"
+
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/i18n"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+)
+
+type privateKey string
+
+const (
+	userKey      privateKey = "user"
+	tokenKey     privateKey = "token"
+	localizerKey privateKey = "localizer"
+)
+
+// WithUser returns a copy of ctx that carries the provided user. The
+// user is also the actor of the audit events created with ctx.
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	var actorID uint
+	if user != nil {
+		actorID = user.ID
+	}
+	ctx = audit.WithActor(ctx, actorID)
+	return context.WithValue(ctx, userKey, user)
+}
+
+// User returns the user stored in ctx, or nil if there is no
+// signed in user for the current request
+func User(ctx context.Context) *models.User {
+	if temp := ctx.Value(userKey); temp != nil {
+		if user, ok := temp.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// WithToken returns a copy of ctx that carries the personal
+// access token the current request was authenticated with
+func WithToken(ctx context.Context, token *models.Token) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+// Token returns the token stored in ctx, or nil if the request
+// was not authenticated with a personal access token
+func Token(ctx context.Context) *models.Token {
+	if temp := ctx.Value(tokenKey); temp != nil {
+		if token, ok := temp.(*models.Token); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// WithLocalizer returns a copy of ctx that carries the localizer
+// for the language chosen for the current request
+func WithLocalizer(ctx context.Context, l *i18n.Localizer) context.Context {
+	return context.WithValue(ctx, localizerKey, l)
+}
+
+// Localizer returns the localizer stored in ctx, falling back to
+// one for i18n.DefaultLocale so callers never have to check for nil
+func Localizer(ctx context.Context) *i18n.Localizer {
+	if temp := ctx.Value(localizerKey); temp != nil {
+		if l, ok := temp.(*i18n.Localizer); ok {
+			return l
+		}
+	}
+	return i18n.New(i18n.DefaultLocale)
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+// eventsPerPage is the number of audit events listed on a page
+const eventsPerPage = 25
+
+// NewAccount is used to create a new Account controller
+// This function will panic if the template is not parsed correctly
+// And should only be used at initial setup
+func NewAccount(ts models.TokenService, rs models.RoleService, is models.IdentityService, events audit.Store, urls *urls.Builder) *Account {
+	return &Account{
+		SecurityView: views.NewView("bootstrap", "account/security").WithMeta(views.Meta{
+			Title:   "meta.security.title",
+			NoIndex: true,
+		}),
+		ts:     ts,
+		rs:     rs,
+		is:     is,
+		events: events,
+		urls:   urls,
+	}
+}
+
+// Account lets signed in users look after their own account. Every
+// route is expected to be behind middleware.RequireUser.
+type Account struct {
+	SecurityView *views.View
+	ts           models.TokenService
+	rs           models.RoleService
+	is           models.IdentityService
+	events       audit.Store
+	urls         *urls.Builder
+}
+
+// EventsData is a page of audit events. NextBefore is the value of
+// the before query parameter for the next page, 0 on the last page.
+type EventsData struct {
+	Events     []audit.Event
+	NextBefore uint
+}
+
+// HasNext reports whether there are older events
+func (d EventsData) HasNext() bool {
+	return d.NextBefore != 0
+}
+
+// Security is used to show the signed in user their security
+// history, eg. logins, failed logins and password changes
+//
+// GET /account/security?before=<event id>
+func (a *Account) Security(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	before, _ := strconv.ParseUint(r.URL.Query().Get("before"), 10, 64)
+	data, err := listEvents(a.events, audit.Filter{
+		UserID:   user.ID,
+		BeforeID: uint(before),
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to list audit events", "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	redactActors(data.Events, user.ID)
+	a.SecurityView.Render(w, r, data)
+}
+
+// Export is used to download everything stored about the signed in
+// user, as a ZIP archive of JSON files or as a single JSON document
+//
+// GET /account/export?format=<zip|json>
+func (a *Account) Export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "json" {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	user := context.User(r.Context())
+	export, err := newAccountExport(user,
+		a.ts.WithContext(r.Context()),
+		a.rs.WithContext(r.Context()),
+		a.is.WithContext(r.Context()),
+		a.events)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to export account", "user_id", user.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	recordEvent(a.events, r, audit.ActionExport, audit.TargetUser, user.ID)
+	filename := fmt.Sprintf("account-%d-%s.%s", user.ID, export.ExportedAt.Format("2006-01-02"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	if format == "json" {
+		writeJSON(w, http.StatusOK, export)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	if err := export.writeZip(w); err != nil {
+		// The headers are already sent, all we can do is log it
+		logging.FromContext(r.Context()).Error("unable to write account export", "user_id", user.ID, "error", err)
+	}
+}
+
+// listEvents returns a page of the events matching f
+func listEvents(events audit.Store, f audit.Filter) (EventsData, error) {
+	f.Limit = eventsPerPage + 1
+	list, err := events.List(f)
+	if err != nil {
+		return EventsData{}, err
+	}
+	var data EventsData
+	if len(list) > eventsPerPage {
+		list = list[:eventsPerPage]
+		data.NextBefore = list[eventsPerPage-1].ID
+	}
+	data.Events = list
+	return data, nil
+}
+
+// redactActors blanks the IP address and user agent of the events
+// another user performed on the user with userID, so the network
+// details of staff are not shown to the users they act on. Events
+// without an actor, eg. failed logins, keep theirs.
+func redactActors(events []audit.Event, userID uint) {
+	for i := range events {
+		if events[i].ActorID != 0 && events[i].ActorID != userID {
+			events[i].IP = ""
+			events[i].UserAgent = ""
+		}
+	}
+}
@@ -0,0 +1,580 @@
+package controllers
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+// NewAdmin is used to create a new Admin controller
+// This function will panic if the template is not parsed correctly
+// And should only be used at initial setup
+func NewAdmin(us models.UserService, roles models.RoleService, events audit.Store, urls *urls.Builder) *Admin {
+	return &Admin{
+		UsersView: views.NewView("admin", "admin/users").WithMeta(views.Meta{
+			Title:   "meta.admin.users.title",
+			NoIndex: true,
+		}),
+		UserView: views.NewView("admin", "admin/user").WithMeta(views.Meta{
+			Title:   "meta.admin.user.title",
+			NoIndex: true,
+		}),
+		AuditView: views.NewView("admin", "admin/audit").WithMeta(views.Meta{
+			Title:   "meta.admin.audit.title",
+			NoIndex: true,
+		}),
+		us:     us,
+		roles:  roles,
+		events: events,
+		urls:   urls,
+	}
+}
+
+// Admin lets admins manage other users. Every route is expected to
+// be behind middleware.Require with the permission listed on it.
+type Admin struct {
+	UsersView *views.View
+	UserView  *views.View
+	AuditView *views.View
+	// DeletionGracePeriod is how long deleted users are kept before
+	// they can be purged
+	DeletionGracePeriod time.Duration
+	us                  models.UserService
+	roles               models.RoleService
+	events              audit.Store
+	urls                *urls.Builder
+}
+
+// AdminUsersData is the data rendered by the user list
+type AdminUsersData struct {
+	Users []models.User
+	Total int
+	// GraceDays is how many days deleted users are kept for
+	GraceDays int
+	Error     string
+
+	// The filters of the list, kept when paging through it
+	Search   string
+	Status   string
+	Verified string
+
+	// After is the cursor of the current page, empty on the first
+	// page, and NextCursor the cursor of the next one
+	After      string
+	NextCursor string
+}
+
+// HasPrev reports whether the current page is not the first one
+func (d AdminUsersData) HasPrev() bool {
+	return d.After != ""
+}
+
+// HasNext reports whether there is a page after the current one
+func (d AdminUsersData) HasNext() bool {
+	return d.NextCursor != ""
+}
+
+// FirstQuery returns the query string of the first page. It is a
+// template.URL so the template does not escape it a second time.
+func (d AdminUsersData) FirstQuery() template.URL {
+	return d.pageQuery("")
+}
+
+// NextQuery returns the query string of the next page
+func (d AdminUsersData) NextQuery() template.URL {
+	return d.pageQuery(d.NextCursor)
+}
+
+// pageQuery returns the query string of the list starting after
+// cursor, keeping the current filters
+func (d AdminUsersData) pageQuery(cursor string) template.URL {
+	q := url.Values{}
+	if d.Search != "" {
+		q.Set("q", d.Search)
+	}
+	if d.Status != "" {
+		q.Set("status", d.Status)
+	}
+	if d.Verified != "" {
+		q.Set("verified", d.Verified)
+	}
+	if cursor != "" {
+		q.Set("after", cursor)
+	}
+	return template.URL(q.Encode())
+}
+
+// AdminUserData is the data rendered by the user details page.
+// Events are the latest events performed by or on the user.
+// Assignable are the roles the user does not have yet that the admin
+// may assign.
+type AdminUserData struct {
+	User       *models.User
+	Roles      []models.Role
+	Assignable []models.Role
+	Events     []audit.Event
+	Error      string
+}
+
+// AdminAuditData is the data rendered by the audit trail, with the
+// filters it was filtered by
+type AdminAuditData struct {
+	EventsData
+	Actions []string
+	Action  string
+	UserID  string
+	ActorID string
+}
+
+// NextQuery returns the query string of the next page, keeping the
+// current filters. It is a template.URL so the template does not
+// escape it a second time.
+func (d AdminAuditData) NextQuery() template.URL {
+	q := url.Values{}
+	if d.Action != "" {
+		q.Set("action", d.Action)
+	}
+	if d.UserID != "" {
+		q.Set("user", d.UserID)
+	}
+	if d.ActorID != "" {
+		q.Set("actor", d.ActorID)
+	}
+	q.Set("before", strconv.FormatUint(uint64(d.NextBefore), 10))
+	return template.URL(q.Encode())
+}
+
+// AssignRoleForm is the form used to assign a role to a user
+type AssignRoleForm struct {
+	RoleID uint `schema:"role_id"`
+}
+
+// Users is used to list every user, newest first, optionally
+// searching them by name or email and filtering them by status
+//
+// GET /admin/users?q=<search>&status=<active|disabled|deleted>&verified=<yes|no>&after=<cursor>
+// Requires users:read
+func (a *Admin) Users(w http.ResponseWriter, r *http.Request) {
+	a.renderUsers(w, r, http.StatusOK, r.URL.Query(), "")
+}
+
+// renderUsers renders the user list filtered by params, with an
+// optional error message
+func (a *Admin) renderUsers(w http.ResponseWriter, r *http.Request, status int, params url.Values, message string) {
+	data := AdminUsersData{
+		Error:    message,
+		Search:   params.Get("q"),
+		Status:   params.Get("status"),
+		Verified: params.Get("verified"),
+		After:    params.Get("after"),
+	}
+	q := models.UserQuery{
+		Search: data.Search,
+		Desc:   true,
+		After:  data.After,
+	}
+	switch data.Status {
+	case "active":
+		q.Disabled = boolPtr(false)
+	case "disabled":
+		q.Disabled = boolPtr(true)
+	case "deleted":
+		q.Deleted = true
+	default:
+		data.Status = ""
+	}
+	switch data.Verified {
+	case "yes":
+		q.Verified = boolPtr(true)
+	case "no":
+		q.Verified = boolPtr(false)
+	default:
+		data.Verified = ""
+	}
+	page, err := a.us.WithContext(r.Context()).Query(q)
+	if err == models.ErrInvalidCursor {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to list users", "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	data.Users = page.Users
+	data.Total = page.Total
+	data.NextCursor = page.NextCursor
+	data.GraceDays = int(a.DeletionGracePeriod.Hours() / 24)
+	a.UsersView.RenderStatus(w, r, status, data)
+}
+
+// User is used to show the details of a user, with the admin
+// actions recently performed on them
+//
+// GET /admin/users/{id}
+// Requires users:read
+func (a *Admin) User(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.user(w, r)
+	if !ok {
+		return
+	}
+	a.render(w, r, http.StatusOK, user, "")
+}
+
+// Audit is used to search the audit trail, filtering it by action,
+// by the user the events involve or by who performed them. Actions
+// ending in "." match every action starting with them.
+//
+// GET /admin/audit?action=<action>&user=<id>&actor=<id>&before=<event id>
+// Requires audit:read
+func (a *Admin) Audit(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	data := AdminAuditData{
+		Actions: audit.Actions,
+		Action:  params.Get("action"),
+		UserID:  params.Get("user"),
+		ActorID: params.Get("actor"),
+	}
+	f := audit.Filter{
+		Action: data.Action,
+	}
+	for _, p := range []struct {
+		value string
+		dst   *uint
+	}{
+		{data.UserID, &f.UserID},
+		{data.ActorID, &f.ActorID},
+		{params.Get("before"), &f.BeforeID},
+	} {
+		if p.value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(p.value, 10, 64)
+		if err != nil {
+			views.Error(w, r, http.StatusBadRequest)
+			return
+		}
+		*p.dst = uint(id)
+	}
+	events, err := listEvents(a.events, f)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to list audit events", "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	data.EventsData = events
+	a.AuditView.Render(w, r, data)
+}
+
+// DisableUser is used to stop a user from signing in, signing them
+// out everywhere
+//
+// POST /admin/users/{id}/disable
+// Requires users:write
+func (a *Admin) DisableUser(w http.ResponseWriter, r *http.Request) {
+	a.act(w, r, audit.ActionUserDisable, func(us models.UserService, user *models.User) error {
+		user.Disabled = true
+		return rotateRemember(us, user)
+	})
+}
+
+// EnableUser is used to let a disabled user sign in again
+//
+// POST /admin/users/{id}/enable
+// Requires users:write
+func (a *Admin) EnableUser(w http.ResponseWriter, r *http.Request) {
+	a.act(w, r, audit.ActionUserEnable, func(us models.UserService, user *models.User) error {
+		user.Disabled = false
+		return us.Update(user)
+	})
+}
+
+// ResetUserPassword is used to sign a user out everywhere and make
+// them choose a new password the next time they sign in
+//
+// POST /admin/users/{id}/reset-password
+// Requires users:write
+func (a *Admin) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	a.act(w, r, audit.ActionUserPasswordReset, func(us models.UserService, user *models.User) error {
+		user.PasswordResetRequired = true
+		return rotateRemember(us, user)
+	})
+}
+
+// SignOutUser is used to sign a user out on every device
+//
+// POST /admin/users/{id}/sign-out
+// Requires users:write
+func (a *Admin) SignOutUser(w http.ResponseWriter, r *http.Request) {
+	a.act(w, r, audit.ActionUserSignOut, rotateRemember)
+}
+
+// DeleteUser is used to delete a user
+//
+// POST /admin/users/{id}/delete
+// Requires users:write
+func (a *Admin) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.user(w, r)
+	if !ok {
+		return
+	}
+	if !a.canChange(w, r, user) {
+		return
+	}
+	// The user service records the delete in the audit trail
+	if err := a.us.WithContext(r.Context()).Delete(user.ID); err != nil {
+		logging.FromContext(r.Context()).Error("unable to delete user", "user_id", user.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	a.urls.Redirect(w, r, "admin.users")
+}
+
+// RestoreUser is used to undo the delete of a user that has not been
+// purged yet. Like every other action on users, only admins can
+// restore admins.
+//
+// POST /admin/users/{id}/restore
+// Requires users:write
+func (a *Admin) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		views.Error(w, r, http.StatusNotFound)
+		return
+	}
+	us := a.us.WithContext(r.Context())
+	user, err := us.DeletedByID(uint(id))
+	switch err {
+	case nil:
+	case models.ErrNotFound:
+		views.Error(w, r, http.StatusNotFound)
+		return
+	default:
+		logging.FromContext(r.Context()).Error("unable to look up deleted user", "user_id", id, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	if user.Admin && !context.User(r.Context()).Admin {
+		a.renderUsers(w, r, http.StatusForbidden, url.Values{"status": {"deleted"}}, translate(r, "admin.users.admins_only"))
+		return
+	}
+	// The user service records the restore in the audit trail
+	switch err := us.Restore(user.ID); err {
+	case nil:
+	case models.ErrNotFound:
+		views.Error(w, r, http.StatusNotFound)
+		return
+	case models.ErrEmailTaken:
+		// Someone signed up with the address since, restoring the
+		// user would give two users the same email address
+		a.renderUsers(w, r, http.StatusConflict, url.Values{"status": {"deleted"}}, translate(r, "admin.users.restore_email_taken"))
+		return
+	default:
+		logging.FromContext(r.Context()).Error("unable to restore user", "user_id", id, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	a.urls.Redirect(w, r, "admin.users.show", "id", strconv.FormatUint(id, 10))
+}
+
+// PurgeUsers is used to permanently remove the users deleted longer
+// than DeletionGracePeriod ago, without waiting for the next purge
+//
+// POST /admin/users/purge
+// Requires users:write
+func (a *Admin) PurgeUsers(w http.ResponseWriter, r *http.Request) {
+	// The user service records every purged user in the audit trail
+	if _, err := a.us.WithContext(r.Context()).Purge(time.Now().Add(-a.DeletionGracePeriod)); err != nil {
+		logging.FromContext(r.Context()).Error("unable to purge deleted users", "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	a.urls.RedirectQuery(w, r, url.Values{"status": {"deleted"}}, "admin.users")
+}
+
+// AssignRole is used to give a user a role
+//
+// POST /admin/users/{id}/roles
+// Requires roles:manage
+func (a *Admin) AssignRole(w http.ResponseWriter, r *http.Request) {
+	var form AssignRoleForm
+	if err := parseForm(r, &form); err != nil {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	a.changeRole(w, r, audit.ActionUserRoleAssign, form.RoleID, models.RoleService.Assign)
+}
+
+// UnassignRole is used to take a role away from a user
+//
+// POST /admin/users/{id}/roles/{role_id}/delete
+// Requires roles:manage
+func (a *Admin) UnassignRole(w http.ResponseWriter, r *http.Request) {
+	roleID, err := strconv.ParseUint(mux.Vars(r)["role_id"], 10, 64)
+	if err != nil {
+		views.Error(w, r, http.StatusNotFound)
+		return
+	}
+	a.changeRole(w, r, audit.ActionUserRoleUnassign, uint(roleID), models.RoleService.Unassign)
+}
+
+// changeRole looks up the user of the request and the role with
+// roleID, then assigns or unassigns it with fn. Admins can not
+// change their own roles, so they do not take away their own
+// permission to manage roles by accident, and can only assign roles
+// granting permissions they have themselves.
+func (a *Admin) changeRole(w http.ResponseWriter, r *http.Request, action string, roleID uint, fn func(rs models.RoleService, userID, roleID uint) error) {
+	user, ok := a.user(w, r)
+	if !ok {
+		return
+	}
+	if !a.canChange(w, r, user) {
+		return
+	}
+	roles := a.roles.WithContext(r.Context())
+	role, err := roles.ByID(roleID)
+	switch err {
+	case nil:
+	case models.ErrNotFound:
+		a.render(w, r, http.StatusUnprocessableEntity, user, translate(r, errorKey(err)))
+		return
+	default:
+		logging.FromContext(r.Context()).Error("unable to look up role", "role_id", roleID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	if action == audit.ActionUserRoleAssign && !canGrant(r, role.PermissionList()) {
+		a.render(w, r, http.StatusForbidden, user, translate(r, "admin.roles.permission_not_held"))
+		return
+	}
+	if err := fn(roles, user.ID, role.ID); err != nil {
+		logging.FromContext(r.Context()).Error("unable to change roles", "action", action, "user_id", user.ID, "role_id", role.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	a.record(r, action, audit.TargetUser, user.ID)
+	a.urls.Redirect(w, r, "admin.users.show", "id", strconv.FormatUint(uint64(user.ID), 10))
+}
+
+// act looks up the user of the request, applies fn to them, records
+// action in the audit trail and sends the admin back to the user.
+// Admins can not perform these actions on themselves, so they do not
+// lock themselves out by accident.
+func (a *Admin) act(w http.ResponseWriter, r *http.Request, action string, fn func(models.UserService, *models.User) error) {
+	user, ok := a.user(w, r)
+	if !ok {
+		return
+	}
+	if !a.canChange(w, r, user) {
+		return
+	}
+	if err := fn(a.us.WithContext(r.Context()), user); err != nil {
+		logging.FromContext(r.Context()).Error("unable to update user", "action", action, "user_id", user.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	a.record(r, action, audit.TargetUser, user.ID)
+	a.urls.Redirect(w, r, "admin.users.show", "id", strconv.FormatUint(uint64(user.ID), 10))
+}
+
+// user looks up the user in the {id} route variable, rendering a
+// 404 page when there is no such user
+func (a *Admin) user(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		views.Error(w, r, http.StatusNotFound)
+		return nil, false
+	}
+	user, err := a.us.WithContext(r.Context()).ByID(uint(id))
+	switch err {
+	case nil:
+		return user, true
+	case models.ErrNotFound:
+		views.Error(w, r, http.StatusNotFound)
+	default:
+		logging.FromContext(r.Context()).Error("unable to look up user", "user_id", id, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+// canChange reports whether the signed in user may act on user,
+// rendering the user page with the reason when they may not. Nobody
+// acts on their own account here, and only admins act on admins.
+func (a *Admin) canChange(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	actor := context.User(r.Context())
+	switch {
+	case actor.ID == user.ID:
+		a.render(w, r, http.StatusBadRequest, user, translate(r, "admin.users.not_yourself"))
+		return false
+	case user.Admin && !actor.Admin:
+		a.render(w, r, http.StatusForbidden, user, translate(r, "admin.users.admins_only"))
+		return false
+	}
+	return true
+}
+
+func (a *Admin) record(r *http.Request, action, targetType string, targetID uint) {
+	recordEvent(a.events, r, action, targetType, targetID)
+}
+
+// recordEvent appends action on a target to the audit trail. The
+// action was already performed, so a failure is logged rather than
+// shown.
+func recordEvent(events audit.Store, r *http.Request, action, targetType string, targetID uint) {
+	actor := context.User(r.Context())
+	event := audit.NewEvent(r, actor.ID, action).Target(targetType, targetID)
+	if err := events.Append(event); err != nil {
+		logging.FromContext(r.Context()).Error("unable to record audit event", "action", action, "error", err)
+	}
+}
+
+func (a *Admin) render(w http.ResponseWriter, r *http.Request, status int, user *models.User, message string) {
+	events, err := a.events.List(audit.Filter{
+		UserID: user.ID,
+		Limit:  20,
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to list audit events", "error", err)
+	}
+	roles := a.roles.WithContext(r.Context())
+	assigned, err := roles.ByUserID(user.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to list roles of user", "user_id", user.ID, "error", err)
+	}
+	all, err := roles.All()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to list roles", "error", err)
+	}
+	has := make(map[uint]bool, len(assigned))
+	for _, role := range assigned {
+		has[role.ID] = true
+	}
+	var assignable []models.Role
+	for _, role := range all {
+		if !has[role.ID] && canGrant(r, role.PermissionList()) {
+			assignable = append(assignable, role)
+		}
+	}
+	a.UserView.RenderStatus(w, r, status, AdminUserData{
+		User:       user,
+		Roles:      assigned,
+		Assignable: assignable,
+		Events:     events,
+		Error:      message,
+	})
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+func TestRestoreUserAdminsOnly(t *testing.T) {
+	us := models.NewMemoryUserService(hash.NewHMAC("admin-test"), nil)
+	moderator := models.User{Name: "Moderator", Email: "moderator@example.com", Password: "password"}
+	admin := models.User{Name: "Admin", Email: "admin@example.com", Password: "password", Admin: true}
+	for _, u := range []*models.User{&moderator, &admin} {
+		if err := us.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := us.Delete(admin.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	urlBuilder := urls.NewBuilder(r)
+	views.URLs = urlBuilder
+	adminC := NewAdmin(us, fakeRoles{}, audit.NewMemoryStore(), urlBuilder)
+	r.HandleFunc("/admin/users/{id}/restore", adminC.RestoreUser).Methods("POST").Name("admin.users.restore")
+	r.HandleFunc("/admin/users/{id}", http.NotFound).Name("admin.users.show")
+	for _, name := range []string{"home", "contact", "login", "signup", "logout", "locale", "tokens",
+		"account.settings", "account.security", "admin.users", "admin.users.purge", "admin.roles", "admin.audit"} {
+		r.HandleFunc("/"+name, http.NotFound).Name(name)
+	}
+
+	restore := func(actor *models.User) int {
+		path := "/admin/users/" + strconv.FormatUint(uint64(admin.ID), 10) + "/restore"
+		req := httptest.NewRequest("POST", path, nil)
+		req = req.WithContext(context.WithUser(req.Context(), actor))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := restore(&moderator); code != http.StatusForbidden {
+		t.Errorf("got %d restoring an admin as a moderator, want %d", code, http.StatusForbidden)
+	}
+	if _, err := us.DeletedByID(admin.ID); err != nil {
+		t.Errorf("got %v looking up the deleted admin, want them to stay deleted", err)
+	}
+
+	other := models.User{Name: "Other", Email: "other@example.com", Admin: true}
+	if code := restore(&other); code != http.StatusFound {
+		t.Errorf("got %d restoring an admin as an admin, want a redirect", code)
+	}
+	if _, err := us.ByID(admin.ID); err != nil {
+		t.Errorf("got %v looking up the restored admin", err)
+	}
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/rbac"
+)
+
+// NewAPI is used to create the controller behind the versioned
+// JSON API. It shares the UserService with the HTML controllers
+// so both speak to the same models.
+func NewAPI(us models.UserService, events audit.Store) *API {
+	return &API{
+		us:     us,
+		events: events,
+	}
+}
+
+type API struct {
+	us     models.UserService
+	events audit.Store
+}
+
+// apiUser is the JSON representation of a user returned by the API.
+// Fields like PasswordHash and RememberHash are intentionally left out.
+type apiUser struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// apiError is the envelope every failed API request responds with
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// CreateUser is used to sign up a new user account
+//
+// POST /api/v1/users
+func (a *API) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var form SignupForm
+	if err := parseJSON(r, &form); err != nil {
+		a.error(w, http.StatusBadRequest, "bad_request", translate(r, "errors.bad_request"))
+		return
+	}
+	user := models.User{
+		Name:     form.Name,
+		Email:    form.Email,
+		Password: form.Password,
+	}
+	err := a.us.WithContext(r.Context()).Create(&user)
+	countAttempt(signups, err)
+	if err != nil {
+		a.modelError(w, r, err)
+		return
+	}
+	if err := signIn(w, a.us.WithContext(r.Context()), &user); err != nil {
+		a.modelError(w, r, err)
+		return
+	}
+	a.respond(w, http.StatusCreated, newAPIUser(&user, true))
+}
+
+// ShowUser is used to look up the public profile of a user. Users
+// can only look up themselves unless they have been granted
+// users:read, so accounts can not be listed by counting up IDs.
+//
+// GET /api/v1/users/{id}
+func (a *API) ShowUser(w http.ResponseWriter, r *http.Request) {
+	current, ok := a.authorize(w, r, models.ScopeRead)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		a.modelError(w, r, models.ErrorInvalidID)
+		return
+	}
+	if uint(id) != current.ID && !rbac.Can(r.Context(), models.PermUsersRead) {
+		// The same as a missing user, so it does not tell which IDs exist
+		a.modelError(w, r, models.ErrNotFound)
+		return
+	}
+	user, err := a.us.WithContext(r.Context()).ByID(uint(id))
+	if err != nil {
+		a.modelError(w, r, err)
+		return
+	}
+	a.respond(w, http.StatusOK, newAPIUser(user, current.ID == user.ID))
+}
+
+// CreateSession is used to log a user in with their email
+// address and password.
+//
+// POST /api/v1/sessions
+func (a *API) CreateSession(w http.ResponseWriter, r *http.Request) {
+	var form LoginForm
+	if err := parseJSON(r, &form); err != nil {
+		a.error(w, http.StatusBadRequest, "bad_request", translate(r, "errors.bad_request"))
+		return
+	}
+	user, err := a.us.WithContext(r.Context()).Authenticate(form.Email, form.Password)
+	countAttempt(logins, err)
+	if err != nil {
+		a.modelError(w, r, err)
+		return
+	}
+	if err := signIn(w, a.us.WithContext(r.Context()), user); err != nil {
+		a.modelError(w, r, err)
+		return
+	}
+	a.respond(w, http.StatusOK, newAPIUser(user, true))
+}
+
+// DeleteSession is used to log the current user out. The remember
+// token is rotated so the old cookie stops working everywhere.
+//
+// DELETE /api/v1/sessions
+func (a *API) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.authorize(w, r, models.ScopeWrite)
+	if !ok {
+		return
+	}
+	if err := signOut(w, a.us.WithContext(r.Context()), user); err != nil {
+		a.modelError(w, r, err)
+		return
+	}
+	recordEvent(a.events, r, audit.ActionLogout, audit.TargetUser, user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Account is used to show the account of the signed in user
+//
+// GET /api/v1/account
+func (a *API) Account(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.authorize(w, r, models.ScopeRead)
+	if !ok {
+		return
+	}
+	a.respond(w, http.StatusOK, newAPIUser(user, true))
+}
+
+// NotFound is used for any request under the API prefix
+// that does not match a route.
+func (a *API) NotFound(w http.ResponseWriter, r *http.Request) {
+	a.modelError(w, r, models.ErrNotFound)
+}
+
+// respond writes data as JSON wrapped in a "data" envelope
+func (a *API) respond(w http.ResponseWriter, status int, data interface{}) {
+	writeJSON(w, status, map[string]interface{}{"data": data})
+}
+
+// error writes an error as JSON wrapped in an "error" envelope
+func (a *API) error(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": apiError{
+			Status:  status,
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
+// authorize returns the signed in user for the request. Requests
+// authenticated with a personal access token must also have been
+// granted scope. If the request is not allowed an error is written
+// and ok will be false.
+func (a *API) authorize(w http.ResponseWriter, r *http.Request, scope string) (user *models.User, ok bool) {
+	user = context.User(r.Context())
+	if user == nil {
+		a.error(w, http.StatusUnauthorized, "unauthorized", translate(r, "errors.unauthorized"))
+		return nil, false
+	}
+	if token := context.Token(r.Context()); token != nil && !token.HasScope(scope) {
+		a.error(w, http.StatusForbidden, "insufficient_scope", translate(r, "errors.insufficient_scope", scope))
+		return nil, false
+	}
+	return user, true
+}
+
+// modelError maps errors returned by the models package
+// to the matching HTTP status and error code. Other public
+// errors are validation errors. Anything we do not recognize
+// is treated as an internal error and its message is not
+// leaked to the client.
+func (a *API) modelError(w http.ResponseWriter, r *http.Request, err error) {
+	key := errorKey(err)
+	message := translate(r, key)
+	switch err {
+	case models.ErrNotFound:
+		a.error(w, http.StatusNotFound, "not_found", message)
+	case models.ErrInvalidPassword:
+		a.error(w, http.StatusUnauthorized, "invalid_password", message)
+	case models.ErrUserDisabled:
+		a.error(w, http.StatusForbidden, "user_disabled", message)
+	case models.ErrorInvalidID:
+		a.error(w, http.StatusBadRequest, "invalid_id", message)
+	case models.ErrEmailTaken:
+		a.error(w, http.StatusConflict, "email_taken", message)
+	default:
+		if _, ok := publicErrors[err]; ok {
+			a.error(w, http.StatusUnprocessableEntity, strings.TrimPrefix(key, "errors."), message)
+			return
+		}
+		logging.FromContext(r.Context()).Error("api request failed", "error", err)
+		a.error(w, http.StatusInternalServerError, "internal", message)
+	}
+}
+
+func newAPIUser(user *models.User, private bool) apiUser {
+	ret := apiUser{
+		ID:        user.ID,
+		Name:      user.Name,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+	if private {
+		ret.Email = user.Email
+	}
+	return ret
+}
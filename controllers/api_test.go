@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+)
+
+func TestShowUserOnlyShowsYourself(t *testing.T) {
+	us := models.NewMemoryUserService(hash.NewHMAC("api-test"), nil)
+	ada := models.User{Name: "Ada", Email: "ada@example.com", Password: "password"}
+	bob := models.User{Name: "Bob", Email: "bob@example.com", Password: "password"}
+	for _, u := range []*models.User{&ada, &bob} {
+		if err := us.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin := models.User{Name: "Admin", Admin: true}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/users/{id:[0-9]+}", NewAPI(us, audit.NewMemoryStore()).ShowUser)
+	for _, tc := range []struct {
+		name  string
+		actor *models.User
+		want  int
+		email bool
+	}{
+		{"visitor", nil, http.StatusUnauthorized, false},
+		{"another user", &bob, http.StatusNotFound, false},
+		{"themselves", &ada, http.StatusOK, true},
+		{"admin", &admin, http.StatusOK, false},
+	} {
+		req := httptest.NewRequest("GET", "/api/v1/users/"+strconv.FormatUint(uint64(ada.ID), 10), nil)
+		if tc.actor != nil {
+			req = req.WithContext(context.WithUser(req.Context(), tc.actor))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, w.Code, tc.want)
+			continue
+		}
+		if w.Code == http.StatusOK && strings.Contains(w.Body.String(), ada.Email) != tc.email {
+			t.Errorf("%s: got %s, the email address should only be shown to Ada", tc.name, w.Body)
+		}
+	}
+}
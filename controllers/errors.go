@@ -0,0 +1,32 @@
+package controllers
+
+import "github.com/vinny-sabatini/web-dev-with-go/models"
+
+// publicErrors maps errors from the models package to the i18n
+// key of the message that is safe to show to users
+var publicErrors = map[error]string{
+	models.ErrNotFound:          "errors.not_found",
+	models.ErrInvalidPassword:   "errors.invalid_password",
+	models.ErrUserDisabled:      "errors.user_disabled",
+	models.ErrEmailRequired:     "errors.email_required",
+	models.ErrEmailInvalid:      "errors.email_invalid",
+	models.ErrEmailTaken:        "errors.email_taken",
+	models.ErrorInvalidID:       "errors.invalid_id",
+	models.ErrTokenExpired:      "errors.token_expired",
+	models.ErrTokenNameRequired: "errors.token_name_required",
+	models.ErrInvalidScope:      "errors.invalid_scope",
+	models.ErrRoleNameRequired:  "errors.role_name_required",
+	models.ErrRoleNameInvalid:   "errors.role_name_invalid",
+	models.ErrRoleNameTaken:     "errors.role_name_taken",
+	models.ErrInvalidPermission: "errors.invalid_permission",
+}
+
+// errorKey returns the i18n key of the public message for err.
+// Errors we do not know about get a generic message, so internal
+// details are never leaked.
+func errorKey(err error) string {
+	if key, ok := publicErrors[err]; ok {
+		return key
+	}
+	return "errors.internal"
+}
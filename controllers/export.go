@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+)
+
+// exportEventsPage is the number of audit events read at a time
+// while exporting an account
+const exportEventsPage = 500
+
+// accountExport is everything stored about a user, as downloaded
+// from their account. Secrets like password and token hashes are
+// left out.
+type accountExport struct {
+	ExportedAt time.Time        `json:"exported_at"`
+	Profile    exportProfile    `json:"profile"`
+	Tokens     []exportToken    `json:"tokens"`
+	Roles      []exportRole     `json:"roles"`
+	Identities []exportIdentity `json:"identities"`
+	Events     []exportEvent    `json:"events"`
+}
+
+type exportProfile struct {
+	ID              uint       `json:"id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	Locale          string     `json:"locale,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+type exportToken struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+type exportRole struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+type exportIdentity struct {
+	Provider string    `json:"provider"`
+	Email    string    `json:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+type exportEvent struct {
+	Action    string    `json:"action"`
+	ActorID   uint      `json:"actor_id,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	TargetID  uint      `json:"target_id,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newAccountExport collects everything stored about user
+func newAccountExport(user *models.User, ts models.TokenService, rs models.RoleService, is models.IdentityService, events audit.Store) (*accountExport, error) {
+	export := &accountExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: exportProfile{
+			ID:              user.ID,
+			Name:            user.Name,
+			Email:           user.Email,
+			Locale:          user.Locale,
+			EmailVerifiedAt: user.EmailVerifiedAt,
+			CreatedAt:       user.CreatedAt,
+			UpdatedAt:       user.UpdatedAt,
+		},
+		Tokens:     []exportToken{},
+		Roles:      []exportRole{},
+		Identities: []exportIdentity{},
+		Events:     []exportEvent{},
+	}
+
+	tokens, err := ts.ByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		export.Tokens = append(export.Tokens, exportToken{
+			Name:       t.Name,
+			Scopes:     strings.Fields(t.Scopes),
+			CreatedAt:  t.CreatedAt,
+			LastUsedAt: t.LastUsedAt,
+			ExpiresAt:  t.ExpiresAt,
+		})
+	}
+
+	roles, err := rs.ByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		export.Roles = append(export.Roles, exportRole{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.PermissionList(),
+		})
+	}
+
+	identities, err := is.ByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ident := range identities {
+		export.Identities = append(export.Identities, exportIdentity{
+			Provider: ident.Provider,
+			Email:    ident.Email,
+			LinkedAt: ident.CreatedAt,
+		})
+	}
+
+	f := audit.Filter{UserID: user.ID, Limit: exportEventsPage}
+	for {
+		list, err := events.List(f)
+		if err != nil {
+			return nil, err
+		}
+		redactActors(list, user.ID)
+		for _, e := range list {
+			export.Events = append(export.Events, exportEvent{
+				Action:    e.Action,
+				ActorID:   e.ActorID,
+				Target:    e.TargetType,
+				TargetID:  e.TargetID,
+				Details:   e.Details,
+				IP:        e.IP,
+				UserAgent: e.UserAgent,
+				CreatedAt: e.CreatedAt,
+			})
+		}
+		if len(list) < exportEventsPage {
+			return export, nil
+		}
+		f.BeforeID = list[len(list)-1].ID
+	}
+}
+
+// writeZip writes the export as a ZIP archive with a JSON file for
+// each part of the account
+func (e *accountExport) writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", e.Profile},
+		{"tokens.json", e.Tokens},
+		{"roles.json", e.Roles},
+		{"identities.json", e.Identities},
+		{"security_events.json", e.Events},
+	}
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: e.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
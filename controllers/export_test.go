@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+)
+
+// fakeTokens is a token service without any tokens
+type fakeTokens struct {
+	models.TokenService
+}
+
+func (fakeTokens) ByUserID(userID uint) ([]models.Token, error) {
+	return nil, nil
+}
+
+// fakeRoles is a role service without any roles
+type fakeRoles struct {
+	models.RoleService
+}
+
+func (fakeRoles) ByUserID(userID uint) ([]models.Role, error) {
+	return nil, nil
+}
+
+func TestAccountExportHidesAdminNetworkDetails(t *testing.T) {
+	events := audit.NewMemoryStore()
+	us := models.NewMemoryUserService(hash.NewHMAC("export-test"), events)
+	admin := models.User{Name: "Admin", Email: "admin@example.com", Password: "password", Admin: true}
+	user := models.User{Name: "Ada", Email: "ada@example.com", Password: "password"}
+	for _, u := range []*models.User{&admin, &user} {
+		if err := us.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/users/{id}", http.NotFound).Name("admin.users.show")
+	adminC := NewAdmin(us, fakeRoles{}, events, urls.NewBuilder(r))
+	r.HandleFunc("/admin/users/{id}/disable", adminC.DisableUser).Methods("POST")
+	req := httptest.NewRequest("POST", "/admin/users/"+strconv.FormatUint(uint64(user.ID), 10)+"/disable", nil)
+	req.RemoteAddr = "203.0.113.9:4321"
+	req.Header.Set("User-Agent", "staff-browser")
+	req = req.WithContext(context.WithUser(req.Context(), &admin))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("got %d disabling the user, want a redirect", w.Code)
+	}
+
+	export, err := newAccountExport(&user, fakeTokens{}, fakeRoles{}, &fakeIdentities{}, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var disabled bool
+	for _, e := range export.Events {
+		if e.Action != audit.ActionUserDisable {
+			continue
+		}
+		disabled = true
+		if e.ActorID != admin.ID {
+			t.Errorf("got actor %d, want the admin %d", e.ActorID, admin.ID)
+		}
+		if e.IP != "" || e.UserAgent != "" {
+			t.Errorf("got IP %q and user agent %q of the admin in the export, want them blank", e.IP, e.UserAgent)
+		}
+	}
+	if !disabled {
+		t.Errorf("got events %+v, want the admin disabling the user", export.Events)
+	}
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkTimeout is how long a single readiness check may take before
+// it is reported as failed
+const checkTimeout = 2 * time.Second
+
+// errCheckTimeout is reported for checks that took too long
+var errCheckTimeout = errors.New("check timed out")
+
+// HealthCheck is a named check run on every readiness probe, eg.
+// pinging the database. It returns nil when the dependency is fine.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// NewHealth is used to create a new Health controller that runs the
+// provided checks for readiness probes
+func NewHealth(checks ...HealthCheck) *Health {
+	return &Health{
+		checks: checks,
+	}
+}
+
+// Health serves the liveness and readiness probes used by load
+// balancers and orchestrators
+type Health struct {
+	checks []HealthCheck
+	// shuttingDown is set once the server starts shutting down, so
+	// traffic is moved elsewhere before connections are closed
+	shuttingDown int32
+}
+
+// HealthData is the JSON body of the health endpoints
+type HealthData struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the outcome of a single readiness check
+type CheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+const (
+	statusOK          = "ok"
+	statusFailing     = "failing"
+	statusUnavailable = "unavailable"
+	statusShutdown    = "shutting_down"
+)
+
+// Live reports that the process is up and able to serve requests.
+// It does not check any dependencies, restarting the app would not
+// fix a database that is down.
+//
+// GET /healthz
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, HealthData{Status: statusOK})
+}
+
+// Ready runs every check and reports whether the app should receive
+// traffic, with the result of each check. It responds with a 503
+// when any check fails or the server is shutting down.
+//
+// GET /readyz
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if atomic.LoadInt32(&h.shuttingDown) == 1 {
+		writeJSON(w, http.StatusServiceUnavailable, HealthData{Status: statusShutdown})
+		return
+	}
+	data := HealthData{
+		Status: statusOK,
+		Checks: h.run(r.Context()),
+	}
+	status := http.StatusOK
+	for _, result := range data.Checks {
+		if result.Status != statusOK {
+			data.Status = statusUnavailable
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, data)
+}
+
+// Shutdown marks the app as not ready, it is called once the server
+// starts shutting down
+func (h *Health) Shutdown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+// run runs every check concurrently, giving each one checkTimeout
+func (h *Health) run(ctx context.Context) map[string]CheckResult {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]CheckResult, len(h.checks))
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c HealthCheck) {
+			defer wg.Done()
+			result := runCheck(ctx, c)
+			mu.Lock()
+			defer mu.Unlock()
+			results[c.Name] = result
+		}(c)
+	}
+	wg.Wait()
+	return results
+}
+
+// runCheck runs a single check. Checks that do not support contexts
+// keep running in the background after timing out, but the probe
+// does not wait for them.
+func runCheck(ctx context.Context, c HealthCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errCheckTimeout
+	}
+	result := CheckResult{
+		Status:     statusOK,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = statusFailing
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -1,20 +1,53 @@
 package controllers
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/schema"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
 )
 
+// maxJSONBody limits how much of a JSON request body we are willing to read
+const maxJSONBody = 1 << 20
+
 func parseForm(r *http.Request, dst interface{}) error {
 	if err := r.ParseForm(); err != nil {
 		return err
 	}
 
 	dec := schema.NewDecoder()
+	// Forms include fields we do not decode, like the CSRF token
+	dec.IgnoreUnknownKeys(true)
 	err := dec.Decode(dst, r.PostForm)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func parseJSON(r *http.Request, dst interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxJSONBody))
+	dec.DisallowUnknownFields()
+	return dec.Decode(dst)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// translate translates the message with the provided key into the
+// language chosen for the request. See i18n.Localizer.T for details.
+func translate(r *http.Request, key string, args ...interface{}) string {
+	return context.Localizer(r.Context()).T(key, args...)
+}
+
+// isLocalPath reports whether path is safe to redirect to without
+// leaving the site. Paths like "//evil.com" are treated as URLs by
+// browsers, so they are rejected.
+func isLocalPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.HasPrefix(path, "/\\")
+}
@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/vinny-sabatini/web-dev-with-go/metrics"
+)
+
+var (
+	signups = metrics.NewCounterVec("signups_total",
+		"Number of signup attempts, by result and the reason they failed.",
+		"result", "reason")
+	logins = metrics.NewCounterVec("logins_total",
+		"Number of login attempts, by result and the reason they failed.",
+		"result", "reason")
+)
+
+// countAttempt records the outcome of a signup or login attempt in
+// counter. Failures are labelled with the public error they map to,
+// eg. "not_found" for models.ErrNotFound.
+func countAttempt(counter *metrics.CounterVec, err error) {
+	if err == nil {
+		counter.Inc("success", "")
+		return
+	}
+	counter.Inc("failure", strings.TrimPrefix(errorKey(err), "errors."))
+}
@@ -0,0 +1,387 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/oauth"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+// oauthCookie keeps the state of a sign in flow while the user is at
+// the provider. It is only sent to the callback.
+const oauthCookie = "oauth_state"
+
+// errEmailUnverified is returned when a provider does not vouch for
+// the email address of a user we have not seen before
+var errEmailUnverified = errors.New("controllers: provider did not share a verified email address")
+
+// errAccountUnverified is returned when the email address a provider
+// shares belongs to a user who never verified it. Whoever signed up
+// with it may not own it, so the identity is only linked once the
+// user signs in and links it from their settings.
+var errAccountUnverified = errors.New("controllers: account with the email address is not verified")
+
+// NewOAuth is used to create a new OAuth controller for providers
+// This function will panic if the template is not parsed correctly
+// And should only be used at initial setup
+func NewOAuth(us models.UserService, is models.IdentityService, events audit.Store, urls *urls.Builder, providers ...*oauth.Provider) *OAuth {
+	return &OAuth{
+		LoginView: views.NewView("auth", "users/login").WithMeta(views.Meta{Title: "meta.login.title"}),
+		providers: providers,
+		us:        us,
+		is:        is,
+		events:    events,
+		urls:      urls,
+	}
+}
+
+// OAuth signs users in with OAuth2 and OpenID Connect providers, and
+// lets signed in users link and unlink their accounts at them
+type OAuth struct {
+	LoginView *views.View
+	// BaseURL is the public URL of the site, providers send users
+	// back to the callback under it
+	BaseURL string
+	// Secure limits the flow cookie to https, set it when the site
+	// is served over https
+	Secure    bool
+	providers []*oauth.Provider
+	us        models.UserService
+	is        models.IdentityService
+	events    audit.Store
+	urls      *urls.Builder
+}
+
+// oauthFlow is what is kept in the oauthCookie. Flows that link an
+// identity remember who started them, so it can only be linked to
+// the same user.
+type oauthFlow struct {
+	oauth.State
+	Link   bool
+	UserID uint
+}
+
+// Login is used to send the user to a provider to sign in
+//
+// GET /oauth/{provider}/login
+func (o *OAuth) Login(w http.ResponseWriter, r *http.Request) {
+	o.start(w, r, oauthFlow{})
+}
+
+// Link is used to send the signed in user to a provider, to link
+// their account there so they can sign in with it
+//
+// POST /account/identities/{provider}
+func (o *OAuth) Link(w http.ResponseWriter, r *http.Request) {
+	o.start(w, r, oauthFlow{Link: true, UserID: context.User(r.Context()).ID})
+}
+
+// start sends the user to the provider of the request with a new
+// flow, remembered in a cookie
+func (o *OAuth) start(w http.ResponseWriter, r *http.Request, flow oauthFlow) {
+	p := o.provider(r)
+	if p == nil {
+		views.Error(w, r, http.StatusNotFound)
+		return
+	}
+	state, err := oauth.NewState()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	flow.State = *state
+	value := url.Values{
+		"state":    {state.State},
+		"nonce":    {state.Nonce},
+		"verifier": {state.Verifier},
+		"user":     {strconv.FormatUint(uint64(flow.UserID), 10)},
+	}
+	if flow.Link {
+		value.Set("link", "1")
+	}
+	callback, _ := o.urls.URL("oauth.callback", "provider", p.Name)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie,
+		Value:    value.Encode(),
+		Path:     callback,
+		MaxAge:   10 * 60,
+		Secure:   o.Secure,
+		HttpOnly: true,
+		// Lax, so the cookie is sent when the provider redirects back
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, p.AuthCodeURL(o.redirectURL(p), state), http.StatusFound)
+}
+
+// Callback is where providers send users back to. New users are
+// signed up, and users with an account for the verified email
+// address the provider shares get the identity linked to it.
+//
+// GET /oauth/{provider}/callback
+func (o *OAuth) Callback(w http.ResponseWriter, r *http.Request) {
+	p := o.provider(r)
+	if p == nil {
+		views.Error(w, r, http.StatusNotFound)
+		return
+	}
+	flow := o.readFlow(w, r)
+	ident, err := p.Callback(r.Context(), o.redirectURL(p), &flow.State, r.URL.Query())
+	if err != nil && err != oauth.ErrDenied {
+		logging.FromContext(r.Context()).Info("oauth callback failed", "provider", p.Name, "error", err)
+	}
+	if flow.Link {
+		o.link(w, r, flow, ident, err)
+		return
+	}
+	if err != nil {
+		key := "users.oauth.failed"
+		if err == oauth.ErrDenied {
+			key = "users.oauth.denied"
+		}
+		o.renderLogin(w, r, translate(r, key, p.DisplayName))
+		return
+	}
+
+	user, err := o.userFor(r, ident)
+	if err == nil && user.Disabled {
+		err = models.ErrUserDisabled
+		e := audit.NewEvent(r, user.ID, audit.ActionLoginFailed).Target(audit.TargetUser, user.ID)
+		e.Details = "disabled " + user.Email
+		o.record(r, e)
+	}
+	countAttempt(logins, err)
+	switch err {
+	case nil:
+	case errEmailUnverified:
+		o.renderLogin(w, r, translate(r, "users.oauth.email_unverified", p.DisplayName))
+		return
+	case errAccountUnverified:
+		o.renderLogin(w, r, translate(r, "users.oauth.link_from_settings", p.DisplayName))
+		return
+	case models.ErrNotFound:
+		// The identity belongs to a user who deleted their account
+		o.renderLogin(w, r, translate(r, "users.oauth.deleted", p.DisplayName))
+		return
+	case models.ErrUserDisabled:
+		o.renderLogin(w, r, translate(r, "errors.user_disabled"))
+		return
+	default:
+		logging.FromContext(r.Context()).Error("unable to sign in with provider", "provider", p.Name, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if err := signIn(w, o.us.WithContext(r.Context()), user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e := audit.NewEvent(r, user.ID, audit.ActionLogin).Target(audit.TargetUser, user.ID)
+	e.Details = p.Name
+	o.record(r, e)
+	o.urls.Redirect(w, r, "cookie_test")
+}
+
+// userFor returns the user ident is linked to. Identities that are
+// not linked yet are linked to the user with the same verified email
+// address, who is signed up first if there is none. Users who never
+// verified the address have to link the identity themselves.
+func (o *OAuth) userFor(r *http.Request, ident *oauth.Identity) (*models.User, error) {
+	us := o.us.WithContext(r.Context())
+	is := o.is.WithContext(r.Context())
+	linked, err := is.ByProvider(ident.Provider, ident.Subject)
+	if err == nil {
+		return us.ByID(linked.UserID)
+	}
+	if err != models.ErrNotFound {
+		return nil, err
+	}
+	if ident.Email == "" || !ident.EmailVerified {
+		return nil, errEmailUnverified
+	}
+
+	now := time.Now()
+	user, err := us.ByEmail(ident.Email)
+	switch err {
+	case models.ErrNotFound:
+		user = &models.User{
+			Name:            strings.TrimSpace(ident.Name),
+			Email:           ident.Email,
+			EmailVerifiedAt: &now,
+		}
+		err = us.Create(user)
+		countAttempt(signups, err)
+	case nil:
+		if !user.Verified() {
+			return nil, errAccountUnverified
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity := models.Identity{
+		UserID:   user.ID,
+		Provider: ident.Provider,
+		Subject:  ident.Subject,
+		Email:    ident.Email,
+	}
+	if err := is.Create(&identity); err != nil {
+		return nil, err
+	}
+	o.record(r, audit.NewEvent(r, user.ID, audit.ActionIdentityLink).Target(audit.TargetIdentity, identity.ID))
+	return user, nil
+}
+
+// link links ident to the signed in user who started the flow
+func (o *OAuth) link(w http.ResponseWriter, r *http.Request, flow oauthFlow, ident *oauth.Identity, err error) {
+	user := context.User(r.Context())
+	if user == nil || user.ID != flow.UserID {
+		o.urls.Redirect(w, r, "login")
+		return
+	}
+	switch err {
+	case nil:
+	case oauth.ErrDenied:
+		o.urls.Redirect(w, r, "account.settings")
+		return
+	default:
+		o.urls.RedirectQuery(w, r, url.Values{"identity_error": {"failed"}}, "account.settings")
+		return
+	}
+
+	is := o.is.WithContext(r.Context())
+	linked, err := is.ByProvider(ident.Provider, ident.Subject)
+	switch {
+	case err == nil && linked.UserID != user.ID:
+		o.urls.RedirectQuery(w, r, url.Values{"identity_error": {"taken"}}, "account.settings")
+		return
+	case err == models.ErrNotFound:
+		identity := models.Identity{
+			UserID:   user.ID,
+			Provider: ident.Provider,
+			Subject:  ident.Subject,
+			Email:    ident.Email,
+		}
+		err = is.Create(&identity)
+		if err == nil {
+			recordEvent(o.events, r, audit.ActionIdentityLink, audit.TargetIdentity, identity.ID)
+		}
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to link identity", "user_id", user.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	o.urls.RedirectQuery(w, r, url.Values{"saved": {"identity_linked"}}, "account.settings")
+}
+
+// Unlink is used to unlink one of the signed in user's identities.
+// Users without a password can not unlink the last one, or they
+// could no longer sign in.
+//
+// POST /account/identities/{id}/delete
+func (o *OAuth) Unlink(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		views.Error(w, r, http.StatusNotFound)
+		return
+	}
+	is := o.is.WithContext(r.Context())
+	identity, err := is.ByID(uint(id))
+	if err != nil || identity.UserID != user.ID {
+		views.Error(w, r, http.StatusNotFound)
+		return
+	}
+	identities, err := is.ByUserID(user.ID)
+	if err == nil && !user.HasPassword() && len(identities) <= 1 {
+		o.urls.RedirectQuery(w, r, url.Values{"identity_error": {"last"}}, "account.settings")
+		return
+	}
+	if err == nil {
+		err = is.Delete(identity.ID)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to unlink identity", "user_id", user.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	recordEvent(o.events, r, audit.ActionIdentityUnlink, audit.TargetIdentity, identity.ID)
+	o.urls.RedirectQuery(w, r, url.Values{"saved": {"identity_unlinked"}}, "account.settings")
+}
+
+// provider returns the provider named in the route, or nil
+func (o *OAuth) provider(r *http.Request) *oauth.Provider {
+	name := mux.Vars(r)["provider"]
+	for _, p := range o.providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// redirectURL is where p sends users back to
+func (o *OAuth) redirectURL(p *oauth.Provider) string {
+	callback, _ := o.urls.URL("oauth.callback", "provider", p.Name)
+	return strings.TrimSuffix(o.BaseURL, "/") + callback
+}
+
+// readFlow returns the flow of the oauthCookie and expires it, so a
+// flow can only be completed once. A missing cookie gives an empty
+// flow, which fails the state check.
+func (o *OAuth) readFlow(w http.ResponseWriter, r *http.Request) oauthFlow {
+	var flow oauthFlow
+	cookie, err := r.Cookie(oauthCookie)
+	if err != nil {
+		return flow
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie,
+		Path:     r.URL.Path,
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		Secure:   o.Secure,
+		HttpOnly: true,
+	})
+	value, err := url.ParseQuery(cookie.Value)
+	if err != nil {
+		return flow
+	}
+	flow.State = oauth.State{
+		State:    value.Get("state"),
+		Nonce:    value.Get("nonce"),
+		Verifier: value.Get("verifier"),
+	}
+	flow.Link = value.Get("link") == "1"
+	userID, _ := strconv.ParseUint(value.Get("user"), 10, 64)
+	flow.UserID = uint(userID)
+	return flow
+}
+
+// renderLogin shows the login page with message, with the same
+// status as a failed login with a password
+func (o *OAuth) renderLogin(w http.ResponseWriter, r *http.Request, message string) {
+	o.LoginView.RenderStatus(w, r, http.StatusUnauthorized, LoginData{
+		Error:     message,
+		Providers: o.providers,
+	})
+}
+
+// record appends e to the audit trail, logging a failure
+func (o *OAuth) record(r *http.Request, e *audit.Event) {
+	if err := o.events.Append(e); err != nil {
+		logging.FromContext(r.Context()).Error("unable to record audit event", "action", e.Action, "error", err)
+	}
+}
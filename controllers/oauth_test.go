@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+	"github.com/vinny-sabatini/web-dev-with-go/middleware"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/oauth"
+	"github.com/vinny-sabatini/web-dev-with-go/oauth/oauthtest"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+// fakeIdentities keeps identities in memory
+type fakeIdentities struct {
+	models.IdentityService
+	identities []models.Identity
+	nextID     uint
+}
+
+func (f *fakeIdentities) WithContext(ctx context.Context) models.IdentityService {
+	return f
+}
+
+func (f *fakeIdentities) ByID(id uint) (*models.Identity, error) {
+	for i := range f.identities {
+		if f.identities[i].ID == id {
+			return &f.identities[i], nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+func (f *fakeIdentities) ByProvider(provider, subject string) (*models.Identity, error) {
+	for i := range f.identities {
+		if f.identities[i].Provider == provider && f.identities[i].Subject == subject {
+			return &f.identities[i], nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+func (f *fakeIdentities) ByUserID(userID uint) ([]models.Identity, error) {
+	var ret []models.Identity
+	for _, identity := range f.identities {
+		if identity.UserID == userID {
+			ret = append(ret, identity)
+		}
+	}
+	return ret, nil
+}
+
+func (f *fakeIdentities) Create(identity *models.Identity) error {
+	f.nextID++
+	identity.Model = gorm.Model{ID: f.nextID, CreatedAt: time.Now()}
+	f.identities = append(f.identities, *identity)
+	return nil
+}
+
+func (f *fakeIdentities) Delete(id uint) error {
+	for i := range f.identities {
+		if f.identities[i].ID == id {
+			f.identities = append(f.identities[:i], f.identities[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+// oauthSite is the site with two providers, "test" and "other", both
+// backed by the same local OpenID Connect provider
+type oauthSite struct {
+	*httptest.Server
+	idp        *oauthtest.Server
+	us         models.UserService
+	identities *fakeIdentities
+	client     *http.Client
+}
+
+func newOAuthSite(t *testing.T) *oauthSite {
+	idp := oauthtest.NewServer()
+	t.Cleanup(idp.Close)
+	test, other := idp.Provider("test"), idp.Provider("other")
+	for _, p := range []*oauth.Provider{test, other} {
+		if err := p.Discover(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	site := &oauthSite{
+		idp:        idp,
+		us:         models.NewMemoryUserService(hash.NewHMAC("oauth-test"), nil),
+		identities: &fakeIdentities{},
+	}
+	events := audit.NewMemoryStore()
+	r := mux.NewRouter()
+	urlBuilder := urls.NewBuilder(r)
+	views.URLs = urlBuilder
+	usersC := NewUsers(site.us, site.identities, events, urlBuilder)
+	usersC.Providers = append(usersC.Providers, test, other)
+	oauthC := NewOAuth(site.us, site.identities, events, urlBuilder, test, other)
+	requireUserMw := middleware.RequireUser{URLs: urlBuilder}
+
+	r.HandleFunc("/login", usersC.LoginPage).Methods("GET").Name("login")
+	r.HandleFunc("/cookieTest", usersC.CookieTest).Methods("GET").Name("cookie_test")
+	r.HandleFunc("/oauth/{provider}/login", oauthC.Login).Methods("GET").Name("oauth.login")
+	r.HandleFunc("/oauth/{provider}/callback", oauthC.Callback).Methods("GET").Name("oauth.callback")
+	r.HandleFunc("/account/settings", requireUserMw.ApplyFn(usersC.Settings)).Methods("GET").Name("account.settings")
+	r.HandleFunc("/account/identities/{provider}", requireUserMw.ApplyFn(oauthC.Link)).Methods("POST").Name("identities.link")
+	r.HandleFunc("/account/identities/{id:[0-9]+}/delete", requireUserMw.ApplyFn(oauthC.Unlink)).Methods("POST").Name("identities.delete")
+	r.HandleFunc("/account/delete", requireUserMw.ApplyFn(usersC.DeleteAccount)).Methods("POST").Name("account.delete")
+	// The rest of the routes the layouts link to
+	for _, name := range []string{"home", "contact", "signup", "signup.create", "login.create", "logout", "locale",
+		"password.reset.update", "tokens", "account.settings.update", "account.password.update", "account.export", "account.security", "admin.users", "admin.roles", "admin.audit"} {
+		r.HandleFunc("/"+name, http.NotFound).Name(name)
+	}
+	r.HandleFunc("/admin/users/{id}", http.NotFound).Name("admin.users.show")
+
+	userMw := middleware.User{UserService: site.us}
+	site.Server = httptest.NewServer(userMw.Apply(r))
+	t.Cleanup(site.Close)
+	oauthC.BaseURL = site.URL
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site.client = &http.Client{Jar: jar}
+	return site
+}
+
+// get follows the redirects of a GET to path, returning the last
+// response and its body
+func (s *oauthSite) get(t *testing.T, path string) (*http.Response, string) {
+	resp, err := s.client.Get(s.URL + path)
+	return s.read(t, resp, err)
+}
+
+// post follows the redirects of a form POST to path
+func (s *oauthSite) post(t *testing.T, path string, form url.Values) (*http.Response, string) {
+	resp, err := s.client.PostForm(s.URL+path, form)
+	return s.read(t, resp, err)
+}
+
+func (s *oauthSite) read(t *testing.T, resp *http.Response, err error) (*http.Response, string) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+// signOut forgets the cookies of the browser
+func (s *oauthSite) signOut(t *testing.T) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.client.Jar = jar
+}
+
+func TestOAuthSignUp(t *testing.T) {
+	site := newOAuthSite(t)
+	site.idp.User = oauthtest.User{Subject: "ada", Email: "ada@example.com", EmailVerified: true, Name: "Ada"}
+
+	resp, _ := site.get(t, "/oauth/test/login")
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/cookieTest" {
+		t.Fatalf("got %s at %s, want to be signed in", resp.Status, resp.Request.URL.Path)
+	}
+	user, err := site.us.ByEmail("ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Ada" || !user.Verified() || user.HasPassword() {
+		t.Errorf("got user %q verified %v with password %v, want a verified Ada without a password", user.Name, user.Verified(), user.HasPassword())
+	}
+	// The remember token is sent to every page, not just the callback
+	root, _ := url.Parse(site.URL + "/")
+	var remembered bool
+	for _, c := range site.client.Jar.Cookies(root) {
+		remembered = remembered || c.Name == "remember_token"
+	}
+	if !remembered {
+		t.Error("the remember_token cookie is not sent to /")
+	}
+
+	// Signing in again uses the linked identity
+	site.signOut(t)
+	site.get(t, "/oauth/test/login")
+	if len(site.identities.identities) != 1 || site.identities.identities[0].UserID != user.ID {
+		t.Errorf("got identities %+v, want one linked to user %d", site.identities.identities, user.ID)
+	}
+}
+
+func TestOAuthLinksVerifiedEmail(t *testing.T) {
+	site := newOAuthSite(t)
+	now := time.Now()
+	user := models.User{Name: "Ada", Email: "ada@example.com", Password: "password", EmailVerifiedAt: &now}
+	if err := site.us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	site.idp.User = oauthtest.User{Subject: "ada", Email: "ada@example.com", EmailVerified: true}
+
+	site.get(t, "/oauth/test/login")
+	identity, err := site.identities.ByProvider("test", "ada")
+	if err != nil || identity.UserID != user.ID {
+		t.Fatalf("got identity %+v, %v, want it linked to user %d", identity, err, user.ID)
+	}
+	if _, err := site.us.Authenticate("ada@example.com", "password"); err != nil {
+		t.Errorf("got %v signing in with the password after linking", err)
+	}
+}
+
+func TestOAuthRejectsUnverifiedEmail(t *testing.T) {
+	site := newOAuthSite(t)
+
+	// The provider does not vouch for the address
+	site.idp.User = oauthtest.User{Subject: "bob", Email: "bob@example.com"}
+	resp, body := site.get(t, "/oauth/test/login")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got %s, want %d like a failed login with a password", resp.Status, http.StatusUnauthorized)
+	}
+	if !strings.Contains(body, "did not share a verified email address") {
+		t.Errorf("got no error signing in without a verified email:\n%s", body)
+	}
+	if _, err := site.us.ByEmail("bob@example.com"); err != models.ErrNotFound {
+		t.Errorf("got %v, want no user signed up", err)
+	}
+
+	// The user who signed up with the address never verified it
+	carol := models.User{Name: "Carol", Email: "carol@example.com", Password: "password"}
+	if err := site.us.Create(&carol); err != nil {
+		t.Fatal(err)
+	}
+	site.idp.User = oauthtest.User{Subject: "carol", Email: "carol@example.com", EmailVerified: true}
+	_, body = site.get(t, "/oauth/test/login")
+	if !strings.Contains(body, "link Test from your account settings") {
+		t.Errorf("got no error signing in to an unverified account:\n%s", body)
+	}
+	if len(site.identities.identities) != 0 {
+		t.Errorf("got identities %+v, want none linked", site.identities.identities)
+	}
+	if _, err := site.us.Authenticate("carol@example.com", "password"); err != nil {
+		t.Errorf("got %v signing in with the password of the unverified account", err)
+	}
+}
+
+func TestOAuthLinkAndUnlink(t *testing.T) {
+	site := newOAuthSite(t)
+	site.idp.User = oauthtest.User{Subject: "ada", Email: "ada@example.com", EmailVerified: true}
+	site.get(t, "/oauth/test/login")
+
+	resp, body := site.post(t, "/account/identities/other", nil)
+	if resp.Request.URL.Query().Get("saved") != "identity_linked" || !strings.Contains(body, "Linked Accounts") {
+		t.Fatalf("got %s, want the identity linked", resp.Request.URL)
+	}
+	if len(site.identities.identities) != 2 {
+		t.Fatalf("got identities %+v, want two", site.identities.identities)
+	}
+
+	first, second := site.identities.identities[0], site.identities.identities[1]
+	resp, _ = site.post(t, "/account/identities/"+strconv.FormatUint(uint64(first.ID), 10)+"/delete", nil)
+	if resp.Request.URL.Query().Get("saved") != "identity_unlinked" {
+		t.Fatalf("got %s, want the identity unlinked", resp.Request.URL)
+	}
+	// Without a password the last identity is how the user signs in
+	resp, _ = site.post(t, "/account/identities/"+strconv.FormatUint(uint64(second.ID), 10)+"/delete", nil)
+	if resp.Request.URL.Query().Get("identity_error") != "last" {
+		t.Errorf("got %s unlinking the last identity, want it refused", resp.Request.URL)
+	}
+	if len(site.identities.identities) != 1 {
+		t.Errorf("got identities %+v, want the last one kept", site.identities.identities)
+	}
+}
+
+func TestDeleteAccountWithoutPassword(t *testing.T) {
+	site := newOAuthSite(t)
+	site.idp.User = oauthtest.User{Subject: "ada", Email: "ada@example.com", EmailVerified: true}
+	site.get(t, "/oauth/test/login")
+
+	// Without a password the email address confirms the delete
+	resp, _ := site.post(t, "/account/delete", nil)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got %s deleting the account unconfirmed, want it refused", resp.Status)
+	}
+	if _, err := site.us.ByEmail("ada@example.com"); err != nil {
+		t.Fatalf("got %v, want the account kept", err)
+	}
+	site.post(t, "/account/delete", url.Values{"email": {"Ada@Example.com"}})
+	if _, err := site.us.ByEmail("ada@example.com"); err != models.ErrNotFound {
+		t.Errorf("got %v, want the account deleted", err)
+	}
+}
@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/rbac"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+// NewRoles is used to create a new Roles controller
+// This function will panic if the template is not parsed correctly
+// And should only be used at initial setup
+func NewRoles(rs models.RoleService, events audit.Store, urls *urls.Builder) *Roles {
+	return &Roles{
+		IndexView: views.NewView("admin", "admin/roles").WithMeta(views.Meta{
+			Title:   "meta.admin.roles.title",
+			NoIndex: true,
+		}),
+		EditView: views.NewView("admin", "admin/role").WithMeta(views.Meta{
+			Title:   "meta.admin.role.title",
+			NoIndex: true,
+		}),
+		rs:     rs,
+		events: events,
+		urls:   urls,
+	}
+}
+
+// Roles lets admins manage roles and the permissions they grant.
+// Every route is expected to be behind middleware.Require with the
+// roles:manage permission.
+type Roles struct {
+	IndexView *views.View
+	EditView  *views.View
+	rs        models.RoleService
+	events    audit.Store
+	urls      *urls.Builder
+}
+
+// RoleForm is used to create and update roles. Permissions are the
+// checked permissions the site knows about, Other any additional
+// permissions separated by spaces.
+type RoleForm struct {
+	Name        string   `schema:"name"`
+	Description string   `schema:"description"`
+	Permissions []string `schema:"permissions"`
+	Other       string   `schema:"other_permissions"`
+}
+
+// permissions returns every permission of the form, space separated
+func (f *RoleForm) permissions() string {
+	perms := append([]string{}, f.Permissions...)
+	return strings.Join(append(perms, f.Other), " ")
+}
+
+// RolesData is the data rendered by the role list
+type RolesData struct {
+	Roles       []models.Role
+	Permissions []string
+	Form        RoleForm
+	Error       string
+}
+
+// RoleData is the data rendered by the role edit page
+type RoleData struct {
+	Role        *models.Role
+	Members     []models.User
+	Permissions []string
+	Form        RoleForm
+	Error       string
+}
+
+// Index is used to list every role
+//
+// GET /admin/roles
+func (rc *Roles) Index(w http.ResponseWriter, r *http.Request) {
+	rc.renderIndex(w, r, http.StatusOK, RolesData{})
+}
+
+// Create is used to create a new role
+//
+// POST /admin/roles
+func (rc *Roles) Create(w http.ResponseWriter, r *http.Request) {
+	var form RoleForm
+	if err := parseForm(r, &form); err != nil {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	role := models.Role{
+		Name:        form.Name,
+		Description: form.Description,
+		Permissions: form.permissions(),
+	}
+	if !canGrant(r, role.PermissionList()) {
+		rc.renderIndex(w, r, http.StatusForbidden, RolesData{
+			Form:  form,
+			Error: translate(r, "admin.roles.permission_not_held"),
+		})
+		return
+	}
+	if err := rc.rs.WithContext(r.Context()).Create(&role); err != nil {
+		if key, ok := publicErrors[err]; ok {
+			rc.renderIndex(w, r, http.StatusUnprocessableEntity, RolesData{
+				Form:  form,
+				Error: translate(r, key),
+			})
+			return
+		}
+		logging.FromContext(r.Context()).Error("unable to create role", "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	recordEvent(rc.events, r, audit.ActionRoleCreate, audit.TargetRole, role.ID)
+	rc.urls.Redirect(w, r, "admin.roles.show", "id", strconv.FormatUint(uint64(role.ID), 10))
+}
+
+// Show is used to edit a role and list the users it is assigned to
+//
+// GET /admin/roles/{id}
+func (rc *Roles) Show(w http.ResponseWriter, r *http.Request) {
+	role, ok := rc.role(w, r)
+	if !ok {
+		return
+	}
+	rc.renderRole(w, r, http.StatusOK, role, newRoleForm(role), "")
+}
+
+// Update is used to rename a role or change its permissions. Only
+// permissions the signed in user has can be added.
+//
+// POST /admin/roles/{id}
+func (rc *Roles) Update(w http.ResponseWriter, r *http.Request) {
+	role, ok := rc.role(w, r)
+	if !ok {
+		return
+	}
+	var form RoleForm
+	if err := parseForm(r, &form); err != nil {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	update := *role
+	update.Name = form.Name
+	update.Description = form.Description
+	update.Permissions = form.permissions()
+	var added []string
+	for _, p := range update.PermissionList() {
+		if !role.HasPermission(p) {
+			added = append(added, p)
+		}
+	}
+	if !canGrant(r, added) {
+		rc.renderRole(w, r, http.StatusForbidden, role, form, translate(r, "admin.roles.permission_not_held"))
+		return
+	}
+	if err := rc.rs.WithContext(r.Context()).Update(&update); err != nil {
+		if key, ok := publicErrors[err]; ok {
+			rc.renderRole(w, r, http.StatusUnprocessableEntity, role, form, translate(r, key))
+			return
+		}
+		logging.FromContext(r.Context()).Error("unable to update role", "role_id", role.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	recordEvent(rc.events, r, audit.ActionRoleUpdate, audit.TargetRole, role.ID)
+	rc.urls.Redirect(w, r, "admin.roles.show", "id", strconv.FormatUint(uint64(role.ID), 10))
+}
+
+// Delete is used to delete a role, taking it away from every user
+//
+// POST /admin/roles/{id}/delete
+func (rc *Roles) Delete(w http.ResponseWriter, r *http.Request) {
+	role, ok := rc.role(w, r)
+	if !ok {
+		return
+	}
+	if err := rc.rs.WithContext(r.Context()).Delete(role.ID); err != nil {
+		logging.FromContext(r.Context()).Error("unable to delete role", "role_id", role.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	recordEvent(rc.events, r, audit.ActionRoleDelete, audit.TargetRole, role.ID)
+	rc.urls.Redirect(w, r, "admin.roles")
+}
+
+// canGrant reports whether the signed in user has every one of
+// permissions, so managing roles never grants anyone more than the
+// manager has
+func canGrant(r *http.Request, permissions []string) bool {
+	for _, p := range permissions {
+		if !rbac.Can(r.Context(), p) {
+			return false
+		}
+	}
+	return true
+}
+
+// role looks up the role in the {id} route variable, rendering a
+// 404 page when there is no such role
+func (rc *Roles) role(w http.ResponseWriter, r *http.Request) (*models.Role, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		views.Error(w, r, http.StatusNotFound)
+		return nil, false
+	}
+	role, err := rc.rs.WithContext(r.Context()).ByID(uint(id))
+	switch err {
+	case nil:
+		return role, true
+	case models.ErrNotFound:
+		views.Error(w, r, http.StatusNotFound)
+	default:
+		logging.FromContext(r.Context()).Error("unable to look up role", "role_id", id, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+// renderIndex fills in every role and renders the role list
+func (rc *Roles) renderIndex(w http.ResponseWriter, r *http.Request, status int, data RolesData) {
+	roles, err := rc.rs.WithContext(r.Context()).All()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to list roles", "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	data.Roles = roles
+	data.Permissions = models.Permissions
+	rc.IndexView.RenderStatus(w, r, status, data)
+}
+
+// renderRole fills in the members of role and renders the edit page
+func (rc *Roles) renderRole(w http.ResponseWriter, r *http.Request, status int, role *models.Role, form RoleForm, message string) {
+	members, err := rc.rs.WithContext(r.Context()).Members(role.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to list role members", "role_id", role.ID, "error", err)
+	}
+	rc.EditView.RenderStatus(w, r, status, RoleData{
+		Role:        role,
+		Members:     members,
+		Permissions: models.Permissions,
+		Form:        form,
+		Error:       message,
+	})
+}
+
+// newRoleForm returns the form to edit role with. The permissions of
+// role the site does not check for yet are listed in Other.
+func newRoleForm(role *models.Role) RoleForm {
+	known := make(map[string]bool, len(models.Permissions))
+	for _, p := range models.Permissions {
+		known[p] = true
+	}
+	form := RoleForm{
+		Name:        role.Name,
+		Description: role.Description,
+	}
+	var other []string
+	for _, p := range strings.Fields(role.Permissions) {
+		if known[p] {
+			form.Permissions = append(form.Permissions, p)
+		} else {
+			other = append(other, p)
+		}
+	}
+	form.Other = strings.Join(other, " ")
+	return form
+}
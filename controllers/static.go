@@ -4,9 +4,18 @@ import "github.com/vinny-sabatini/web-dev-with-go/views"
 
 func NewStatic() *Static {
 	return &Static{
-		Home:     views.NewView("bootstrap", "static/home"),
-		Contact:  views.NewView("bootstrap", "static/contact"),
-		NotFound: views.NewView("bootstrap", "static/notFound"),
+		Home: views.NewView("bootstrap", "static/home").WithMeta(views.Meta{
+			Title:       "meta.home.title",
+			Description: "meta.home.description",
+		}),
+		Contact: views.NewView("bootstrap", "static/contact").WithMeta(views.Meta{
+			Title:       "meta.contact.title",
+			Description: "meta.contact.description",
+		}),
+		NotFound: views.NewView("bootstrap", "static/notFound").WithMeta(views.Meta{
+			Title:   "meta.not_found.title",
+			NoIndex: true,
+		}),
 	}
 }
 
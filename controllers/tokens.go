@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+// NewTokens is used to create a new Tokens controller
+// This function will panic if the template is not parsed correctly
+// And should only be used at initial setup
+func NewTokens(ts models.TokenService, events audit.Store, urls *urls.Builder) *Tokens {
+	return &Tokens{
+		IndexView: views.NewView("bootstrap", "tokens/index").WithMeta(views.Meta{
+			Title:   "meta.tokens.title",
+			NoIndex: true,
+		}),
+		ts:     ts,
+		events: events,
+		urls:   urls,
+	}
+}
+
+type Tokens struct {
+	IndexView *views.View
+	ts        models.TokenService
+	events    audit.Store
+	urls      *urls.Builder
+}
+
+type TokenForm struct {
+	Name      string   `schema:"name"`
+	Scopes    []string `schema:"scopes"`
+	ExpiresIn int      `schema:"expires_in"`
+}
+
+// TokensData is the data rendered by the tokens page. NewToken is
+// only set right after a token is created, since that is the only
+// time the raw token value is available.
+type TokensData struct {
+	Tokens   []models.Token
+	Scopes   []string
+	NewToken *models.Token
+	Error    string
+}
+
+// Index is used to list the personal access tokens of the
+// signed in user
+//
+// GET /account/tokens
+func (t *Tokens) Index(w http.ResponseWriter, r *http.Request) {
+	t.render(w, r, TokensData{})
+}
+
+// Create is used to create a new personal access token. The raw
+// token is rendered once and can not be looked up again.
+//
+// POST /account/tokens
+func (t *Tokens) Create(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var form TokenForm
+	if err := parseForm(r, &form); err != nil {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	token := models.Token{
+		UserID: user.ID,
+		Name:   form.Name,
+		Scopes: strings.Join(form.Scopes, " "),
+	}
+	if form.ExpiresIn > 0 {
+		expires := time.Now().AddDate(0, 0, form.ExpiresIn)
+		token.ExpiresAt = &expires
+	}
+	if err := t.ts.WithContext(r.Context()).Create(&token); err != nil {
+		switch err {
+		case models.ErrTokenNameRequired, models.ErrInvalidScope:
+			t.render(w, r, TokensData{Error: translate(r, errorKey(err))})
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	recordEvent(t.events, r, audit.ActionTokenCreate, audit.TargetToken, token.ID)
+	t.render(w, r, TokensData{NewToken: &token})
+}
+
+// Delete is used to revoke one of the signed in user's tokens
+//
+// POST /account/tokens/{id}/delete
+func (t *Tokens) Delete(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusNotFound)
+		return
+	}
+	token, err := t.ts.WithContext(r.Context()).ByID(uint(id))
+	if err != nil || token.UserID != user.ID {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err := t.ts.WithContext(r.Context()).Delete(token.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordEvent(t.events, r, audit.ActionTokenRevoke, audit.TargetToken, token.ID)
+	t.urls.Redirect(w, r, "tokens")
+}
+
+// render fills in the signed in user's tokens and renders the index view
+func (t *Tokens) render(w http.ResponseWriter, r *http.Request, data TokensData) {
+	user := context.User(r.Context())
+	tokens, err := t.ts.WithContext(r.Context()).ByUserID(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.Tokens = tokens
+	data.Scopes = models.TokenScopes
+	t.IndexView.Render(w, r, data)
+}
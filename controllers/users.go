@@ -3,45 +3,99 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/i18n"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/middleware"
 	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/oauth"
 	"github.com/vinny-sabatini/web-dev-with-go/rand"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
 	"github.com/vinny-sabatini/web-dev-with-go/views"
 )
 
 // NewUsers is used to create a new Users controller
 // This function will panic if the template is not parsed correctly
 // And should only be used at initial setup
-func NewUsers(us models.UserService) *Users {
+func NewUsers(us models.UserService, is models.IdentityService, events audit.Store, urls *urls.Builder) *Users {
 	return &Users{
-		NewView:   views.NewView("bootstrap", "users/new"),
-		LoginView: views.NewView("bootstrap", "users/login"),
-		us:        us,
+		NewView:   views.NewView("auth", "users/new").WithMeta(views.Meta{Title: "meta.signup.title"}),
+		LoginView: views.NewView("auth", "users/login").WithMeta(views.Meta{Title: "meta.login.title"}),
+		ResetPasswordView: views.NewView("auth", "users/reset_password").WithMeta(views.Meta{
+			Title:   "meta.reset_password.title",
+			NoIndex: true,
+		}),
+		SettingsView: views.NewView("bootstrap", "users/settings").WithMeta(views.Meta{
+			Title:   "meta.settings.title",
+			NoIndex: true,
+		}),
+		us:     us,
+		is:     is,
+		events: events,
+		urls:   urls,
 	}
 }
 
 type Users struct {
-	NewView   *views.View
-	LoginView *views.View
+	NewView           *views.View
+	LoginView         *views.View
+	ResetPasswordView *views.View
+	SettingsView      *views.View
+	// DeletionGracePeriod is how long deleted accounts are kept
+	// before they are purged, shown when deleting an account
+	DeletionGracePeriod time.Duration
+	// Providers are the identity providers users can sign in with,
+	// shown on the login, signup and settings pages
+	Providers []*oauth.Provider
 	us        models.UserService
+	is        models.IdentityService
+	events    audit.Store
+	urls      *urls.Builder
 }
 
 // New is used to render the form where a new user can create an account
 //
 // GET /signup
 func (u *Users) New(w http.ResponseWriter, r *http.Request) {
-	u.NewView.Render(w, nil)
+	u.NewView.Render(w, r, SignupData{Providers: u.Providers})
+}
+
+// LoginPage is used to render the login form
+//
+// GET /login
+func (u *Users) LoginPage(w http.ResponseWriter, r *http.Request) {
+	u.LoginView.Render(w, r, LoginData{Providers: u.Providers})
+}
+
+// SignupData is the data rendered by the signup page
+type SignupData struct {
+	Name      string
+	Email     string
+	Error     string
+	Providers []*oauth.Provider
 }
 
 type SignupForm struct {
-	Name     string `schema:"name"`
-	Email    string `schema:"email"`
-	Password string `schema:"password"`
+	Name     string `schema:"name" json:"name"`
+	Email    string `schema:"email" json:"email"`
+	Password string `schema:"password" json:"password"`
+}
+
+// LoginData is the data rendered by the login page
+type LoginData struct {
+	Email     string
+	Error     string
+	Providers []*oauth.Provider
 }
 
 type LoginForm struct {
-	Email    string `schema:"email"`
-	Password string `schema:"password"`
+	Email    string `schema:"email" json:"email"`
+	Password string `schema:"password" json:"password"`
 }
 
 // Create is used to process the signup form when a user submits it
@@ -52,22 +106,35 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 	var form SignupForm
 	err := parseForm(r, &form)
 	if err != nil {
-		panic(err)
+		views.Error(w, r, http.StatusBadRequest)
+		return
 	}
 	user := models.User{
 		Name:     form.Name,
 		Email:    form.Email,
 		Password: form.Password,
 	}
-	if err := u.us.Create(&user); err != nil {
+	err = u.us.WithContext(r.Context()).Create(&user)
+	countAttempt(signups, err)
+	if err != nil {
+		if key, ok := publicErrors[err]; ok {
+			u.NewView.RenderStatus(w, r, http.StatusUnprocessableEntity, SignupData{
+				Name:      form.Name,
+				Email:     form.Email,
+				Error:     translate(r, key),
+				Providers: u.Providers,
+			})
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = u.signIn(w, &user)
+	err = u.signIn(w, r, &user)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	http.Redirect(w, r, "/cookieTest", http.StatusFound)
+	u.urls.Redirect(w, r, "cookie_test")
 }
 
 // Login is used to verify the provided email address and
@@ -78,50 +145,448 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	var form LoginForm
 	err := parseForm(r, &form)
 	if err != nil {
-		panic(err)
+		views.Error(w, r, http.StatusBadRequest)
+		return
 	}
 
-	user, err := u.us.Authenticate(form.Email, form.Password)
+	user, err := u.us.WithContext(r.Context()).Authenticate(form.Email, form.Password)
+	countAttempt(logins, err)
 	if err != nil {
+		data := LoginData{
+			Email:     form.Email,
+			Providers: u.Providers,
+		}
 		switch err {
 		case models.ErrNotFound:
-			fmt.Fprintln(w, "Invalid email address")
+			data.Error = translate(r, "users.login.invalid_email")
 		case models.ErrInvalidPassword:
-			fmt.Fprintln(w, "Invalid password provided")
+			data.Error = translate(r, "users.login.invalid_password")
+		case models.ErrUserDisabled:
+			data.Error = translate(r, "errors.user_disabled")
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		u.LoginView.RenderStatus(w, r, http.StatusUnauthorized, data)
+		return
+	}
+
+	err = u.signIn(w, r, user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u.urls.Redirect(w, r, "cookie_test")
+}
+
+type ResetPasswordForm struct {
+	Password             string `schema:"password"`
+	PasswordConfirmation string `schema:"password_confirmation"`
+}
+
+// ResetPasswordData is the data rendered by the password reset page
+type ResetPasswordData struct {
+	Error string
+}
+
+// ResetPassword is used to render the form where users an admin
+// asked to reset their password choose a new one
+//
+// GET /account/password/reset
+func (u *Users) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	u.ResetPasswordView.Render(w, r, ResetPasswordData{})
+}
+
+// UpdateResetPassword is used to save the new password chosen on
+// the password reset page. Every other device is signed out, since
+// the reset may be because the account was taken over.
+//
+// POST /account/password/reset
+func (u *Users) UpdateResetPassword(w http.ResponseWriter, r *http.Request) {
+	var form ResetPasswordForm
+	if err := parseForm(r, &form); err != nil {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	var data ResetPasswordData
+	switch {
+	case form.Password == "":
+		data.Error = translate(r, "users.reset_password.required")
+	case form.Password != form.PasswordConfirmation:
+		data.Error = translate(r, "users.reset_password.mismatch")
+	}
+	if data.Error != "" {
+		u.ResetPasswordView.RenderStatus(w, r, http.StatusUnprocessableEntity, data)
+		return
+	}
+	user := context.User(r.Context())
+	us := u.us.WithContext(r.Context())
+	user.Password = form.Password
+	user.PasswordResetRequired = false
+	err := rotateRemember(us, user)
+	if err == nil {
+		// Keep the current device signed in with the new token
+		err = signIn(w, us, user)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to reset password", "user_id", user.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	u.urls.Redirect(w, r, "home")
+}
+
+// SettingsForm is used to change the name and email address of the
+// signed in user
+type SettingsForm struct {
+	Name  string `schema:"name"`
+	Email string `schema:"email"`
+}
+
+// ChangePasswordForm is used to change the password of the signed
+// in user, who has to enter their current one
+type ChangePasswordForm struct {
+	CurrentPassword      string `schema:"current_password"`
+	Password             string `schema:"password"`
+	PasswordConfirmation string `schema:"password_confirmation"`
+}
+
+// SettingsData is the data rendered by the account settings page.
+// Saved is what was just saved, eg. "profile" or "password".
+type SettingsData struct {
+	Form          SettingsForm
+	Verified      bool
+	HasPassword   bool
+	Saved         string
+	ProfileError  string
+	PasswordError string
+	IdentityError string
+	DeleteError   string
+	// GraceDays is how many days a deleted account is kept for
+	GraceDays int
+	// Identities are the linked identities, and Linkable the
+	// providers the user has not linked yet
+	Identities []IdentityData
+	Linkable   []*oauth.Provider
+}
+
+// IdentityData is a linked identity shown on the settings page.
+// DisplayName falls back to Provider once the provider is disabled.
+type IdentityData struct {
+	ID          uint
+	Provider    string
+	DisplayName string
+	Email       string
+	LinkedAt    time.Time
+}
+
+// identityErrors maps the identity_error query parameter of the
+// settings page to the i18n key of its message
+var identityErrors = map[string]string{
+	"taken":  "users.settings.identity_taken",
+	"last":   "users.settings.identity_last",
+	"failed": "users.settings.identity_failed",
+}
+
+// DeleteAccountForm is used to delete the account of the signed in
+// user, who has to confirm it with their password. Users without a
+// password type their email address instead.
+type DeleteAccountForm struct {
+	Password string `schema:"password"`
+	Email    string `schema:"email"`
+}
+
+// Settings is used to render the account settings of the signed in
+// user
+//
+// GET /account/settings?saved=<what>&identity_error=<taken|last|failed>
+func (u *Users) Settings(w http.ResponseWriter, r *http.Request) {
+	data := u.settingsData(r, context.User(r.Context()))
+	data.Saved = r.URL.Query().Get("saved")
+	if key, ok := identityErrors[r.URL.Query().Get("identity_error")]; ok {
+		data.IdentityError = translate(r, key)
+	}
+	u.SettingsView.Render(w, r, data)
+}
+
+// UpdateSettings is used to save the name and email address of the
+// signed in user. A new email address has to be verified again.
+//
+// POST /account/settings
+func (u *Users) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var form SettingsForm
+	if err := parseForm(r, &form); err != nil {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	user := context.User(r.Context())
+	update := *user
+	update.Name = strings.TrimSpace(form.Name)
+	update.Email = form.Email
+	if err := u.us.WithContext(r.Context()).Update(&update); err != nil {
+		if key, ok := publicErrors[err]; ok {
+			data := u.settingsData(r, user)
+			data.Form = form
+			data.ProfileError = translate(r, key)
+			u.SettingsView.RenderStatus(w, r, http.StatusUnprocessableEntity, data)
+			return
+		}
+		logging.FromContext(r.Context()).Error("unable to update account settings", "user_id", user.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	u.urls.RedirectQuery(w, r, url.Values{"saved": {"profile"}}, "account.settings")
+}
+
+// ChangePassword is used to change the password of the signed in
+// user. Every other device they were signed in on is signed out.
+//
+// POST /account/password
+func (u *Users) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var form ChangePasswordForm
+	if err := parseForm(r, &form); err != nil {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	user := context.User(r.Context())
+	data := u.settingsData(r, user)
+	switch {
+	case form.Password == "":
+		data.PasswordError = translate(r, "users.reset_password.required")
+	case form.Password != form.PasswordConfirmation:
+		data.PasswordError = translate(r, "users.reset_password.mismatch")
+	}
+	if data.PasswordError != "" {
+		u.SettingsView.RenderStatus(w, r, http.StatusUnprocessableEntity, data)
+		return
+	}
+	us := u.us.WithContext(r.Context())
+	err := us.ChangePassword(user, form.CurrentPassword, form.Password)
+	if err == models.ErrInvalidPassword {
+		data.PasswordError = translate(r, "users.settings.current_password_invalid")
+		u.SettingsView.RenderStatus(w, r, http.StatusUnprocessableEntity, data)
+		return
+	}
+	if err == nil {
+		// Keep the current device signed in with the new token
+		err = signIn(w, us, user)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to change password", "user_id", user.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	u.urls.RedirectQuery(w, r, url.Values{"saved": {"password"}}, "account.settings")
+}
+
+// DeleteAccount is used to delete the account of the signed in user
+// once they confirm their password, or their email address if they
+// have no password. The account is signed out everywhere and kept for
+// DeletionGracePeriod before it is purged.
+//
+// POST /account/delete
+func (u *Users) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var form DeleteAccountForm
+	if err := parseForm(r, &form); err != nil {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	user := context.User(r.Context())
+	us := u.us.WithContext(r.Context())
+	if !user.HasPassword() {
+		if !strings.EqualFold(strings.TrimSpace(form.Email), user.Email) {
+			data := u.settingsData(r, user)
+			data.DeleteError = translate(r, "users.settings.delete_email_mismatch")
+			u.SettingsView.RenderStatus(w, r, http.StatusUnprocessableEntity, data)
+			return
+		}
+	} else if err := us.CheckPassword(user, form.Password); err != nil {
+		if err != models.ErrInvalidPassword {
+			logging.FromContext(r.Context()).Error("unable to check password", "user_id", user.ID, "error", err)
+			views.Error(w, r, http.StatusInternalServerError)
+			return
 		}
+		data := u.settingsData(r, user)
+		data.DeleteError = translate(r, "users.settings.current_password_invalid")
+		u.SettingsView.RenderStatus(w, r, http.StatusUnprocessableEntity, data)
+		return
+	}
+	err := signOut(w, us, user)
+	if err == nil {
+		err = us.Delete(user.ID)
 	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to delete account", "user_id", user.ID, "error", err)
+		views.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	u.urls.Redirect(w, r, "home")
+}
 
-	err = u.signIn(w, user)
+// settingsData returns the settings page data of user. If their
+// identities can not be listed the page is shown without them.
+func (u *Users) settingsData(r *http.Request, user *models.User) SettingsData {
+	data := SettingsData{
+		Form: SettingsForm{
+			Name:  user.Name,
+			Email: user.Email,
+		},
+		Verified:    user.Verified(),
+		HasPassword: user.HasPassword(),
+		GraceDays:   int(u.DeletionGracePeriod.Hours() / 24),
+	}
+	identities, err := u.is.WithContext(r.Context()).ByUserID(user.ID)
 	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to list identities", "user_id", user.ID, "error", err)
+		return data
+	}
+	names := make(map[string]string, len(u.Providers))
+	for _, p := range u.Providers {
+		names[p.Name] = p.DisplayName
+	}
+	linked := make(map[string]bool, len(identities))
+	for _, ident := range identities {
+		linked[ident.Provider] = true
+		name := names[ident.Provider]
+		if name == "" {
+			name = ident.Provider
+		}
+		data.Identities = append(data.Identities, IdentityData{
+			ID:          ident.ID,
+			Provider:    ident.Provider,
+			DisplayName: name,
+			Email:       ident.Email,
+			LinkedAt:    ident.CreatedAt,
+		})
+	}
+	for _, p := range u.Providers {
+		if !linked[p.Name] {
+			data.Linkable = append(data.Linkable, p)
+		}
+	}
+	return data
+}
+
+type LocaleForm struct {
+	Locale string `schema:"locale"`
+}
+
+// SetLocale is used to change the language the site is shown in.
+// The choice is stored in a cookie, and saved as the preference
+// of the signed in user so it follows them to other devices.
+//
+// POST /locale
+func (u *Users) SetLocale(w http.ResponseWriter, r *http.Request) {
+	var form LocaleForm
+	if err := parseForm(r, &form); err != nil || !i18n.Supported(form.Locale) {
+		views.Error(w, r, http.StatusBadRequest)
+		return
+	}
+	if user := context.User(r.Context()); user != nil {
+		user.Locale = form.Locale
+		err := u.us.WithContext(r.Context()).Update(user)
+		if _, ok := publicErrors[err]; ok {
+			// The user has data that no longer validates, eg. an email
+			// address from before the rules changed. The cookie still
+			// sets the language on this device.
+			logging.FromContext(r.Context()).Info("unable to save locale preference", "user_id", user.ID, "error", err)
+		} else if err != nil {
+			logging.FromContext(r.Context()).Error("unable to save locale preference", "user_id", user.ID, "error", err)
+			views.Error(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+	cookie := http.Cookie{
+		Name:     middleware.LocaleCookie,
+		Value:    form.Locale,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+	// Send the user back to the page they were on. Only the path of
+	// the referer is used so we never redirect to another site.
+	if ref, err := url.Parse(r.Referer()); err == nil && isLocalPath(ref.Path) {
+		http.Redirect(w, r, ref.Path, http.StatusFound)
+		return
+	}
+	u.urls.Redirect(w, r, "home")
+}
+
+// Logout is used to sign the current user out everywhere
+//
+// POST /logout
+func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if err := signOut(w, u.us.WithContext(r.Context()), user); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	http.Redirect(w, r, "/cookieTest", http.StatusFound)
+	recordEvent(u.events, r, audit.ActionLogout, audit.TargetUser, user.ID)
+	u.urls.Redirect(w, r, "home")
 }
 
 // signIn is used to sign a user in via cookies
-func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
+func (u *Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	return signIn(w, u.us.WithContext(r.Context()), user)
+}
+
+// signIn sets the remember_token cookie for the provided user,
+// generating and storing a new remember token if needed.
+// It is shared by the HTML and API controllers.
+func signIn(w http.ResponseWriter, us models.UserService, user *models.User) error {
 	if user.Remember == "" {
 		token, err := rand.RememberToken()
 		if err != nil {
 			return err
 		}
 		user.Remember = token
-		err = u.us.Update(user)
+		err = us.Update(user)
 		if err != nil {
 			return err
 		}
 	}
+	cookie := http.Cookie{
+		Name:  "remember_token",
+		Value: user.Remember,
+		// Users also sign in from deeper paths, eg. OAuth callbacks
+		Path:     "/",
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+// signOut rotates the remember token of the provided user so
+// any existing cookies stop working, and then expires the
+// remember_token cookie on the current client.
+func signOut(w http.ResponseWriter, us models.UserService, user *models.User) error {
+	if err := rotateRemember(us, user); err != nil {
+		return err
+	}
 	cookie := http.Cookie{
 		Name:     "remember_token",
-		Value:    user.Remember,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
 		HttpOnly: true,
 	}
 	http.SetCookie(w, &cookie)
 	return nil
 }
 
+// rotateRemember gives user a new remember token and saves it, which
+// signs them out on every device they were signed in on
+func rotateRemember(us models.UserService, user *models.User) error {
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	user.Remember = token
+	return us.Update(user)
+}
+
 // Cookie test is used to display cookies set on current user
 //
 // GET /cookieTest
@@ -131,7 +596,7 @@ func (u *Users) CookieTest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	user, err := u.us.ByRemember(cookie.Value)
+	user, err := u.us.WithContext(r.Context()).ByRemember(cookie.Value)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+func TestUpdateResetPasswordSignsOutOtherDevices(t *testing.T) {
+	us := models.NewMemoryUserService(hash.NewHMAC("users-test"), nil)
+	user := models.User{Name: "Ada", Email: "ada@example.com", Password: "password", PasswordResetRequired: true}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	stolen := user.Remember
+
+	r := mux.NewRouter()
+	r.HandleFunc("/", http.NotFound).Name("home")
+	usersC := NewUsers(us, &fakeIdentities{}, audit.NewMemoryStore(), urls.NewBuilder(r))
+	form := url.Values{"password": {"new password"}, "password_confirmation": {"new password"}}
+	req := httptest.NewRequest("POST", "/account/password/reset", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithUser(req.Context(), &user))
+	w := httptest.NewRecorder()
+	usersC.UpdateResetPassword(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("got %d, want a redirect", w.Code)
+	}
+	if _, err := us.ByRemember(stolen); err != models.ErrNotFound {
+		t.Errorf("got %v for the remember token from before the reset, want ErrNotFound", err)
+	}
+	// This device stays signed in
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "remember_token" {
+		t.Fatalf("got cookies %v, want a new remember_token", cookies)
+	}
+	got, err := us.ByRemember(cookies[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PasswordResetRequired {
+		t.Error("the password still has to be reset")
+	}
+}
+
+func TestLoginFailureStatus(t *testing.T) {
+	us := models.NewMemoryUserService(hash.NewHMAC("users-test"), nil)
+	user := models.User{Name: "Ada", Email: "ada@example.com", Password: "password"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	urlBuilder := urls.NewBuilder(r)
+	views.URLs = urlBuilder
+	for _, name := range []string{"home", "login.create", "locale", "cookie_test"} {
+		r.HandleFunc("/"+name, http.NotFound).Name(name)
+	}
+	usersC := NewUsers(us, &fakeIdentities{}, audit.NewMemoryStore(), urlBuilder)
+	for _, tc := range []struct {
+		email, password string
+		want            int
+	}{
+		{"ada@example.com", "password", http.StatusFound},
+		{"ada@example.com", "wrong", http.StatusUnauthorized},
+		{"nobody@example.com", "password", http.StatusUnauthorized},
+	} {
+		form := url.Values{"email": {tc.email}, "password": {tc.password}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		usersC.Login(w, req)
+		if w.Code != tc.want {
+			t.Errorf("got %d signing in as %s with %q, want %d", w.Code, tc.email, tc.password, tc.want)
+		}
+	}
+}
@@ -3,9 +3,11 @@ module github.com/vinny-sabatini/web-dev-with-go
 go 1.16
 
 require (
+	github.com/gorilla/csrf v1.6.2
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/schema v1.2.0
 	github.com/jinzhu/gorm v1.9.16
 	github.com/lib/pq v1.10.3 // indirect
+	github.com/yuin/goldmark v1.4.12
 	golang.org/x/crypto v0.0.0-20211115234514-b4de73f9ece8
 )
@@ -0,0 +1,150 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultLocale is used when nothing better matches a request, and
+// for any key that is missing from another locale's catalog
+const DefaultLocale = "en"
+
+//go:embed locales/*.json
+var embedded embed.FS
+
+// catalogs holds the messages of every supported locale, keyed by locale
+var catalogs = mustLoadCatalogs()
+
+// Catalog maps message keys to their translations for one locale
+type Catalog map[string]Message
+
+// Message is a single translated message. Messages that depend on a
+// count have a form per plural category (eg. "one" and "other"), all
+// other messages only have an "other" form.
+type Message map[string]string
+
+// UnmarshalJSON allows messages to be written as a plain string, or
+// as an object of plural forms, eg. {"one": "%d token", "other": "%d tokens"}
+func (m *Message) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*m = Message{"other": s}
+		return nil
+	}
+	var forms map[string]string
+	if err := json.Unmarshal(b, &forms); err != nil {
+		return err
+	}
+	*m = Message(forms)
+	return nil
+}
+
+// Locales returns every supported locale, sorted
+func Locales() []string {
+	ret := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		ret = append(ret, locale)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Supported reports whether there is a catalog for locale
+func Supported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// Localizer translates messages into a single locale
+type Localizer struct {
+	Locale string
+}
+
+// New returns a Localizer for locale, falling back to
+// DefaultLocale if it is not supported
+func New(locale string) *Localizer {
+	if !Supported(locale) {
+		locale = DefaultLocale
+	}
+	return &Localizer{
+		Locale: locale,
+	}
+}
+
+// Has reports whether key is in the catalog of the localizer's
+// locale, or in the DefaultLocale catalog it falls back to
+func (l *Localizer) Has(key string) bool {
+	if _, ok := catalogs[l.Locale][key]; ok {
+		return true
+	}
+	_, ok := catalogs[DefaultLocale][key]
+	return ok
+}
+
+// T translates the message with the provided key and formats it
+// with args like fmt.Sprintf. If the message has plural forms the
+// first arg must be the count used to pick one.
+//
+// Eg. T("tokens.count", 3) could result in "3 tokens"
+//
+// Unknown keys are returned as is, so missing translations are
+// easy to spot without breaking the page.
+func (l *Localizer) T(key string, args ...interface{}) string {
+	msg, ok := catalogs[l.Locale][key]
+	if !ok {
+		msg, ok = catalogs[DefaultLocale][key]
+		if !ok {
+			return key
+		}
+	}
+	form := msg["other"]
+	if len(msg) > 1 && len(args) > 0 {
+		if count, ok := toInt(args[0]); ok {
+			if f, ok := msg[PluralCategory(l.Locale, count)]; ok {
+				form = f
+			}
+		}
+	}
+	if len(args) == 0 {
+		return form
+	}
+	return fmt.Sprintf(form, args...)
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case uint:
+		return int(n), true
+	}
+	return 0, false
+}
+
+func mustLoadCatalogs() map[string]Catalog {
+	files, err := embedded.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	ret := make(map[string]Catalog, len(files))
+	for _, f := range files {
+		b, err := embedded.ReadFile(path.Join("locales", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var c Catalog
+		if err := json.Unmarshal(b, &c); err != nil {
+			// Catalogs are embedded, so this is caught the first time
+			// the app (or the tests) are run after a bad edit.
+			panic(fmt.Errorf("i18n: parsing %s: %w", f.Name(), err))
+		}
+		ret[strings.TrimSuffix(f.Name(), ".json")] = c
+	}
+	return ret
+}
@@ -0,0 +1,65 @@
+package i18n
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCatalogsHaveEveryKey(t *testing.T) {
+	for key, msg := range catalogs[DefaultLocale] {
+		for _, locale := range Locales() {
+			translated, ok := catalogs[locale][key]
+			if !ok {
+				t.Errorf("%s: missing key %q", locale, key)
+				continue
+			}
+			if len(msg) > 1 {
+				for _, category := range PluralCategories(locale) {
+					if _, ok := translated[category]; !ok {
+						t.Errorf("%s: key %q is missing the %q plural form", locale, key, category)
+					}
+				}
+			}
+			if got, want := strings.Count(translated["other"], "%"), strings.Count(msg["other"], "%"); got != want {
+				t.Errorf("%s: key %q has %d format verbs, expected %d", locale, key, got, want)
+			}
+		}
+	}
+	for _, locale := range Locales() {
+		for key := range catalogs[locale] {
+			if _, ok := catalogs[DefaultLocale][key]; !ok {
+				t.Errorf("%s: key %q is not in the %s catalog", locale, key, DefaultLocale)
+			}
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	en, es := New("en"), New("es")
+	if got := en.T("tokens.count", 1); got != "1 token" {
+		t.Errorf("Expected %q, got %q", "1 token", got)
+	}
+	if got := es.T("tokens.create.expires_days", 30); got != "30 días" {
+		t.Errorf("Expected %q, got %q", "30 días", got)
+	}
+	if got := es.T("not.a.key"); got != "not.a.key" {
+		t.Errorf("Expected unknown keys to be returned as is, got %q", got)
+	}
+	if got := New("xx").Locale; got != DefaultLocale {
+		t.Errorf("Expected unsupported locales to fall back to %s, got %s", DefaultLocale, got)
+	}
+}
+
+func TestMatchAcceptLanguage(t *testing.T) {
+	langs := ParseAcceptLanguage("fr-CA, es-MX;q=0.9, en;q=0.8, de;q=0")
+	if want := []string{"fr-CA", "es-MX", "en"}; !reflect.DeepEqual(langs, want) {
+		t.Fatalf("Expected %v, got %v", want, langs)
+	}
+	if got := Match(langs...); got != "es" {
+		t.Errorf("Expected es, got %s", got)
+	}
+	if got := Match("", "de"); got != DefaultLocale {
+		t.Errorf("Expected %s, got %s", DefaultLocale, got)
+	}
+}
@@ -0,0 +1,67 @@
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Match returns the first supported locale out of candidates, which
+// are checked in order. Empty candidates are skipped, and region
+// subtags are ignored, so "es-MX" matches "es". If nothing matches
+// DefaultLocale is returned.
+func Match(candidates ...string) string {
+	for _, c := range candidates {
+		if locale := base(c); locale != "" && Supported(locale) {
+			return locale
+		}
+	}
+	return DefaultLocale
+}
+
+// ParseAcceptLanguage returns the languages of an Accept-Language
+// header, most preferred first
+//
+// Eg. "es-MX,es;q=0.9,en;q=0.8" would result in {"es-MX", "es", "en"}
+func ParseAcceptLanguage(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		l := lang{tag: strings.TrimSpace(fields[0]), q: 1}
+		if l.tag == "" || l.tag == "*" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					l.q = q
+				}
+			}
+		}
+		if l.q > 0 {
+			langs = append(langs, l)
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+	ret := make([]string, len(langs))
+	for i, l := range langs {
+		ret[i] = l.tag
+	}
+	return ret
+}
+
+// base returns the lower cased language subtag of a locale
+func base(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+	return locale
+}
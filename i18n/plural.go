@@ -0,0 +1,38 @@
+package i18n
+
+// pluralRules map a count to its CLDR plural category for each
+// language. Languages without a rule use the English one.
+var pluralRules = map[string]func(n int) string{
+	"en": oneOther,
+	"es": oneOther,
+}
+
+// PluralCategory returns the plural category ("one", "other", ...)
+// that count falls in for locale
+func PluralCategory(locale string, count int) string {
+	rule, ok := pluralRules[locale]
+	if !ok {
+		rule = pluralRules[DefaultLocale]
+	}
+	return rule(count)
+}
+
+// PluralCategories returns every plural category used by locale
+func PluralCategories(locale string) []string {
+	seen := make(map[string]bool)
+	var ret []string
+	for n := 0; n < 200; n++ {
+		if c := PluralCategory(locale, n); !seen[c] {
+			seen[c] = true
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+func oneOther(n int) string {
+	if n == 1 {
+		return "one"
+	}
+	return "other"
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"context"
+	"sync"
+)
+
+type privateKey string
+
+const (
+	loggerKey    privateKey = "logger"
+	requestIDKey privateKey = "request_id"
+	fieldsKey    privateKey = "fields"
+)
+
+// NewContext returns a copy of ctx that carries the provided logger
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the logger stored in ctx, falling back to
+// Default so callers never have to check for nil
+func FromContext(ctx context.Context) *Logger {
+	if temp := ctx.Value(loggerKey); temp != nil {
+		if l, ok := temp.(*Logger); ok {
+			return l
+		}
+	}
+	return Default()
+}
+
+// WithRequestID returns a copy of ctx that carries the ID of the
+// current request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID of the current request, or an empty
+// string when ctx does not belong to a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// fields collects values about a request as it is being handled,
+// eg. the route it matched, so they can be logged once it is done.
+// Handlers further down the chain only see copies of the request,
+// so the fields are shared through a pointer.
+type fields struct {
+	mu sync.Mutex
+	kv []interface{}
+}
+
+// WithFields returns a copy of ctx that SetField can add to
+func WithFields(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fieldsKey, &fields{})
+}
+
+// SetField records key and value in the fields of ctx, replacing any
+// earlier value for key. It does nothing if ctx has no fields.
+func SetField(ctx context.Context, key string, value interface{}) {
+	f, ok := ctx.Value(fieldsKey).(*fields)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < len(f.kv); i += 2 {
+		if f.kv[i] == key {
+			f.kv[i+1] = value
+			return
+		}
+	}
+	f.kv = append(f.kv, key, value)
+}
+
+// Fields returns the key value pairs recorded in ctx with SetField
+func Fields(ctx context.Context) []interface{} {
+	f, ok := ctx.Value(fieldsKey).(*fields)
+	if !ok {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kv := make([]interface{}, len(f.kv))
+	copy(kv, f.kv)
+	return kv
+}
@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"fmt"
+	"time"
+)
+
+// GormLogger adapts a Logger to the logger interface of gorm, so SQL
+// statements are written as structured lines, eg.
+//
+//	db.SetLogger(logging.GormLogger{Logger: l})
+//
+// Only the query with its placeholders is logged, never the values,
+// so password hashes and tokens stay out of the logs.
+type GormLogger struct {
+	*Logger
+}
+
+// Print is called by gorm with the values of a log entry. SQL entries
+// are "sql", source, duration, query, values, rows affected and other
+// entries are "log", source, messages...
+func (g GormLogger) Print(v ...interface{}) {
+	if len(v) < 2 {
+		return
+	}
+	switch v[0] {
+	case "sql":
+		if len(v) < 6 {
+			return
+		}
+		duration, _ := v[2].(time.Duration)
+		g.Info("sql",
+			"source", v[1],
+			"query", v[3],
+			"rows", v[5],
+			"duration_ms", float64(duration.Microseconds())/1000,
+		)
+	default:
+		g.Error("gorm", "source", v[1], "error", fmt.Sprint(v[2:]...))
+	}
+}
@@ -0,0 +1,193 @@
+// Package logging writes structured, one line per event logs in
+// either JSON or logfmt, and carries request scoped loggers and
+// request IDs through a context.Context.
+//
+// It lives in its own package, rather than in context, so the
+// models package can log with the request ID without an import cycle.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is the encoding used for each log line
+type Format string
+
+const (
+	// JSON writes every line as a JSON object,
+	// eg. {"time":"...","level":"info","msg":"request"}
+	JSON Format = "json"
+
+	// Logfmt writes every line as key=value pairs,
+	// eg. time=... level=info msg=request
+	Logfmt Format = "logfmt"
+)
+
+// ParseFormat returns the Format named s, eg. for a command line flag
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case JSON, Logfmt:
+		return f, nil
+	}
+	return "", fmt.Errorf("logging: unknown format %q, expected %q or %q", s, JSON, Logfmt)
+}
+
+// Logger writes structured log lines to an io.Writer. It is safe
+// for concurrent use, loggers created with With share the writer.
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	format Format
+	fields []interface{}
+}
+
+// New returns a Logger writing lines in format to out
+func New(out io.Writer, format Format) *Logger {
+	return &Logger{
+		mu:     &sync.Mutex{},
+		out:    out,
+		format: format,
+	}
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = New(os.Stderr, Logfmt)
+)
+
+// Default returns the logger used when there is none in the context
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the logger returned by Default
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// With returns a logger that adds the provided key value pairs to
+// every line it writes
+//
+// Eg. l.With("request_id", id).Info("sql", "rows", 1)
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{
+		mu:     l.mu,
+		out:    l.out,
+		format: l.format,
+		fields: fields,
+	}
+}
+
+// Info writes a line with the "info" level
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.write("info", msg, kv)
+}
+
+// Error writes a line with the "error" level
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.write("error", msg, kv)
+}
+
+func (l *Logger) write(level, msg string, kv []interface{}) {
+	all := make([]interface{}, 0, 6+len(l.fields)+len(kv))
+	all = append(all, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level, "msg", msg)
+	all = append(all, l.fields...)
+	all = append(all, kv...)
+
+	var buf bytes.Buffer
+	if l.format == JSON {
+		encodeJSON(&buf, all)
+	} else {
+		encodeLogfmt(&buf, all)
+	}
+	buf.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+// pairs calls fn for every key value pair in kv. A trailing key
+// without a value is reported with the value "MISSING".
+func pairs(kv []interface{}, fn func(key string, value interface{})) {
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		var value interface{} = "MISSING"
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+		fn(key, value)
+	}
+}
+
+func encodeJSON(buf *bytes.Buffer, kv []interface{}) {
+	buf.WriteByte('{')
+	first := true
+	pairs(kv, func(key string, value interface{}) {
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		switch v := value.(type) {
+		case error:
+			value = v.Error()
+		case time.Duration:
+			value = v.String()
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			b, _ = json.Marshal(fmt.Sprint(value))
+		}
+		buf.Write(b)
+	})
+	buf.WriteByte('}')
+}
+
+func encodeLogfmt(buf *bytes.Buffer, kv []interface{}) {
+	first := true
+	pairs(kv, func(key string, value interface{}) {
+		if !first {
+			buf.WriteByte(' ')
+		}
+		first = false
+		buf.WriteString(logfmtKey(key))
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(fmt.Sprint(value)))
+	})
+}
+
+// logfmtKey replaces the characters that are not allowed in keys
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue quotes values that would otherwise be ambiguous
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " =\"\\") || strings.IndexFunc(v, func(r rune) bool { return r < ' ' }) >= 0 {
+		return strconv.Quote(v)
+	}
+	return v
+}
@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, JSON).With("request_id", "abc").Error("failed", "status", 500, "error", errors.New("boom"))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"level":      "error",
+		"msg":        "failed",
+		"request_id": "abc",
+		"status":     float64(500),
+		"error":      "boom",
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, line[k])
+		}
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, Logfmt).Info("request", "path", "/", "query", `SELECT * FROM "users"`, "empty", "", "odd")
+
+	got := buf.String()
+	got = got[strings.Index(got, " level="):]
+	want := ` level=info msg=request path=/ query="SELECT * FROM \"users\"" empty="" odd=MISSING` + "\n"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestFields(t *testing.T) {
+	ctx := WithFields(context.Background())
+	SetField(ctx, "route", "home")
+	SetField(ctx, "user_id", 1)
+	SetField(ctx, "route", "login")
+
+	got := Fields(ctx)
+	if len(got) != 4 || got[1] != "login" || got[3] != 1 {
+		t.Errorf("Expected fields [route login user_id 1], got %v", got)
+	}
+
+	// Contexts without fields are ignored
+	SetField(context.Background(), "route", "home")
+}
+
+func TestGormLogger(t *testing.T) {
+	var buf bytes.Buffer
+	g := GormLogger{Logger: New(&buf, JSON).With("request_id", "abc")}
+	g.Print("sql", "models/users.go:42", 1500*time.Microsecond, `SELECT * FROM "users" WHERE email = $1`, []interface{}{"secret@example.com"}, int64(1))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["request_id"] != "abc" || line["duration_ms"] != 1.5 || line["rows"] != float64(1) {
+		t.Errorf("Unexpected SQL log line %v", line)
+	}
+	if strings.Contains(buf.String(), "secret@example.com") {
+		t.Errorf("Expected query values to be left out, got %s", buf.String())
+	}
+}
@@ -1,60 +1,78 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"net/http"
+	"os"
 
-	"github.com/gorilla/mux"
-	"github.com/vinny-sabatini/web-dev-with-go/controllers"
-	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
 )
 
-// This should be pulled in as environment, but just for testing...
-const (
-	host = "localhost"
-	port = 5432
-	// Personal Laptop
-	// user     = "vinnysabatini"
-	// password = "notrealpassword"
-	// dbname   = "vinnysabatini"
-	// Work Laptop
-	user     = "postgres"
-	password = ""
-	dbname   = "postgres"
-)
+const usage = `usage: web-dev-with-go [flags] [command] [arguments]
 
-func main() {
-	psqlInfo := fmt.Sprintf("host=%s port=%d password=%s user=%s dbname=%s sslmode=disable", host, port, password, user, dbname)
-	us, err := models.NewUserService(psqlInfo)
-	if err != nil {
-		panic(err)
-	}
-	defer us.Close()
+commands:
+  serve     serve the site, the default when no command is given
+  migrate   apply, revert or list the database migrations
+  db        reset the database
+  user      create, list and manage users
+  roles     create roles and assign them to users
+  seed      fill the database with demo data
 
-	us.AutoMigrate()
+Run a command without arguments to see its usage.
 
-	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(us)
+flags:
+`
 
-	r := mux.NewRouter()
-
-	// Static controllers
-	r.Handle("/", staticC.Home).Methods("GET")
-	r.Handle("/contact", staticC.Contact).Methods("GET")
-	r.NotFoundHandler = http.HandlerFunc(staticC.NotFound.ServeHTTP)
+func main() {
+	cfg, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	//
-	r.HandleFunc("/signup", usersC.New).Methods("GET")
-	r.HandleFunc("/signup", usersC.Create).Methods("POST")
-	r.Handle("/login", usersC.LoginView).Methods("GET")
-	r.HandleFunc("/login", usersC.Login).Methods("POST")
-	r.HandleFunc("/cookieTest", usersC.CookieTest).Methods("GET")
+	// Commands print their output to stdout, so logs go to stderr
+	logOut := os.Stdout
+	if cfg.Command != "serve" {
+		logOut = os.Stderr
+	}
+	logger := logging.New(logOut, cfg.LogFormat)
+	logging.SetDefault(logger)
 
-	http.ListenAndServe(":3000", r)
+	if err := run(cfg, logger); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 }
 
-func must(err error) {
+// run runs the command of cfg
+func run(cfg *config, logger *logging.Logger) error {
+	switch cfg.Command {
+	case "serve":
+		return runServe(cfg, logger)
+	case "migrate":
+		return runMigrate(cfg, os.Stdout, cfg.Args)
+	case "db":
+		return runDB(cfg, os.Stdin, os.Stdout, cfg.Args)
+	case "user", "roles", "seed":
+	default:
+		return fmt.Errorf("unknown command %q, run with -h to list the commands", cfg.Command)
+	}
+
+	// The other commands work on an up to date schema
+	services, err := cfg.openServices(true)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	defer services.Close()
+	switch cfg.Command {
+	case "user":
+		return runUser(services, os.Stdin, os.Stdout, cfg.Args, cfg.DeletionGracePeriod)
+	case "seed":
+		return runSeed(services, os.Stdout, cfg.Args)
+	default:
+		return runRoles(services, os.Stdout, cfg.Args)
 	}
 }
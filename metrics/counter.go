@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+// CounterVec is a counter partitioned by labels, eg. the number of
+// requests per route. Counters only ever go up.
+type CounterVec struct {
+	desc   *desc
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounterVec creates a counter and registers it with Default
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec creates a counter and registers it with reg
+func (reg *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   &desc{name: name, help: help, typ: "counter", labels: labels},
+		values: make(map[string]*counterValue),
+	}
+	reg.register(c)
+	return c
+}
+
+// Inc adds one to the counter with the provided label values, which
+// must be in the same order as the labels of the counter
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter with the
+// provided label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counters can not decrease")
+	}
+	c.desc.checkLabels(labelValues)
+	key := seriesKey(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = cv
+	}
+	cv.value += v
+}
+
+// Value returns the current value of the counter with the provided
+// label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cv, ok := c.values[seriesKey(labelValues)]; ok {
+		return cv.value
+	}
+	return 0
+}
+
+func (c *CounterVec) describe() *desc {
+	return c.desc
+}
+
+func (c *CounterVec) collect(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cv := c.values[key]
+		writeSample(w, c.desc.name, c.desc.labels, cv.labelValues, "", cv.value)
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import "database/sql"
+
+// RegisterDBStats registers the connection pool statistics returned
+// by stats with reg, using the metric names of the official
+// Prometheus client, eg. go_sql_open_connections.
+func (reg *Registry) RegisterDBStats(stats func() sql.DBStats) {
+	gauges := []struct {
+		name, help string
+		value      func(s sql.DBStats) float64
+	}{
+		{"go_sql_max_open_connections", "Maximum number of open connections to the database.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"go_sql_open_connections", "The number of established connections both in use and idle.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"go_sql_in_use_connections", "The number of connections currently in use.", func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"go_sql_idle_connections", "The number of idle connections.", func(s sql.DBStats) float64 { return float64(s.Idle) }},
+	}
+	for _, g := range gauges {
+		value := g.value
+		reg.NewGaugeFunc(g.name, g.help, func() float64 { return value(stats()) })
+	}
+	counters := []struct {
+		name, help string
+		value      func(s sql.DBStats) float64
+	}{
+		{"go_sql_wait_count_total", "The total number of connections waited for.", func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"go_sql_wait_duration_seconds_total", "The total time blocked waiting for a new connection.", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"go_sql_max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns.", func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"go_sql_max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime.", func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+	for _, c := range counters {
+		value := c.value
+		reg.NewCounterFunc(c.name, c.help, func() float64 { return value(stats()) })
+	}
+}
+
+// RegisterDBStats registers database connection pool statistics
+// with Default
+func RegisterDBStats(stats func() sql.DBStats) {
+	Default.RegisterDBStats(stats)
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import "io"
+
+// valueFunc is a metric without labels whose value is read from a
+// function every time the metrics are scraped
+type valueFunc struct {
+	desc *desc
+	fn   func() float64
+}
+
+// NewGaugeFunc registers a gauge with Default whose value is read
+// from fn on every scrape, eg. the number of open connections
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.NewGaugeFunc(name, help, fn)
+}
+
+// NewGaugeFunc registers a gauge with reg whose value is read from
+// fn on every scrape
+func (reg *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	reg.register(&valueFunc{
+		desc: &desc{name: name, help: help, typ: "gauge"},
+		fn:   fn,
+	})
+}
+
+// NewCounterFunc registers a counter with Default whose value is
+// read from fn on every scrape, for totals kept elsewhere
+func NewCounterFunc(name, help string, fn func() float64) {
+	Default.NewCounterFunc(name, help, fn)
+}
+
+// NewCounterFunc registers a counter with reg whose value is read
+// from fn on every scrape
+func (reg *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	reg.register(&valueFunc{
+		desc: &desc{name: name, help: help, typ: "counter"},
+		fn:   fn,
+	})
+}
+
+func (f *valueFunc) describe() *desc {
+	return f.desc
+}
+
+func (f *valueFunc) collect(w io.Writer) {
+	writeSample(w, f.desc.name, nil, nil, "", f.fn())
+}
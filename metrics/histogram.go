@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, which
+// suit the latency of a typical web request
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramVec counts observations, eg. request latencies, in
+// buckets and is partitioned by labels
+type HistogramVec struct {
+	desc    *desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues []string
+	// counts holds the number of observations per bucket, they are
+	// only made cumulative when written out
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates a histogram and registers it with Default.
+// If buckets is nil DefBuckets are used.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewHistogramVec creates a histogram and registers it with reg.
+// If buckets is nil DefBuckets are used.
+func (reg *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{
+		desc:    &desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	reg.register(h)
+	return h
+}
+
+// Observe records v in the histogram with the provided label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.desc.checkLabels(labelValues)
+	key := seriesKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = hv
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// ObserveSince records the seconds passed since start, eg.
+//
+//	defer h.ObserveSince(time.Now(), "hash")
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Count returns the number of observations in the histogram with the
+// provided label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hv, ok := h.values[seriesKey(labelValues)]; ok {
+		return hv.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) describe() *desc {
+	return h.desc
+}
+
+func (h *HistogramVec) collect(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		hv := h.values[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hv.counts[i]
+			writeSample(w, h.desc.name+"_bucket", h.desc.labels, hv.labelValues, `le="`+formatFloat(le)+`"`, float64(cumulative))
+		}
+		writeSample(w, h.desc.name+"_bucket", h.desc.labels, hv.labelValues, `le="+Inf"`, float64(hv.count))
+		writeSample(w, h.desc.name+"_sum", h.desc.labels, hv.labelValues, "", hv.sum)
+		writeSample(w, h.desc.name+"_count", h.desc.labels, hv.labelValues, "", float64(hv.count))
+	}
+}
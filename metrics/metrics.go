@@ -0,0 +1,171 @@
+// Package metrics keeps counters, gauges and histograms in memory
+// and exposes them in the Prometheus text format, eg.
+//
+//	# HELP http_requests_total Number of HTTP requests handled.
+//	# TYPE http_requests_total counter
+//	http_requests_total{route="home",method="GET",code="200"} 42
+//
+// Metrics are created once, usually as package level variables, and
+// registered with Default unless another Registry is used.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector is implemented by every kind of metric
+type collector interface {
+	describe() *desc
+	collect(w io.Writer)
+}
+
+// desc is the name, help text and labels shared by every series of
+// a metric
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+// Registry is a set of metrics that are exposed together
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]collector),
+	}
+}
+
+// Default is the registry metrics are added to by the package level
+// constructors, eg. NewCounterVec
+var Default = NewRegistry()
+
+// register adds c to the registry. Metric names have to be unique,
+// registering one twice is a bug so we panic like template.Must does.
+func (reg *Registry) register(c collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	d := c.describe()
+	if _, ok := reg.collectors[d.name]; ok {
+		panic(fmt.Sprintf("metrics: %s is already registered", d.name))
+	}
+	reg.collectors[d.name] = c
+}
+
+// WriteTo writes every metric in the registry to w in the
+// Prometheus text format, sorted by name
+func (reg *Registry) WriteTo(w io.Writer) (int64, error) {
+	reg.mu.Lock()
+	names := make([]string, 0, len(reg.collectors))
+	for name := range reg.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, reg.collectors[name])
+	}
+	reg.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, c := range collectors {
+		d := c.describe()
+		fmt.Fprintf(&buf, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", d.name, d.typ)
+		c.collect(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// Handler returns a handler serving the metrics in reg, for
+// Prometheus to scrape
+func (reg *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		reg.WriteTo(w)
+	})
+}
+
+// Handler serves the metrics registered with Default
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// seriesKey joins label values into a map key. Label values may
+// contain anything, so they are separated by a byte that is not
+// valid UTF-8.
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// checkLabels panics when the number of label values does not match
+// the labels of d, since that can only be caused by a bug in our code
+func (d *desc) checkLabels(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+}
+
+// writeSample writes one line of the text format, eg.
+// name{route="home",le="0.5"} 3
+func writeSample(w io.Writer, name string, labels, values []string, extra string, v float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 || extra != "" {
+		io.WriteString(w, "{")
+		for i, l := range labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			io.WriteString(w, l)
+			io.WriteString(w, `="`)
+			labelEscaper.WriteString(w, values[i])
+			io.WriteString(w, `"`)
+		}
+		if extra != "" {
+			if len(labels) > 0 {
+				io.WriteString(w, ",")
+			}
+			io.WriteString(w, extra)
+		}
+		io.WriteString(w, "}")
+	}
+	io.WriteString(w, " ")
+	io.WriteString(w, formatFloat(v))
+	io.WriteString(w, "\n")
+}
+
+// labelEscaper escapes a label value the way the text format
+// expects. Only backslashes, quotes and newlines are escaped, every
+// other byte is written as it is.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	help = strings.ReplaceAll(help, `\`, `\\`)
+	return strings.ReplaceAll(help, "\n", `\n`)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
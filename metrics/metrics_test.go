@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounterVec("requests_total", "Number of requests.", "route", "code")
+	latency := reg.NewHistogramVec("latency_seconds", "Request latency.\nIn seconds.", []float64{1, 0.1}, "route")
+	reg.NewGaugeFunc("up", "Whether the app is up.", func() float64 { return 1 })
+
+	requests.Inc("home", "200")
+	requests.Add(2, "home", "200")
+	requests.Inc(`say "hi"`, "404")
+	latency.Observe(0.05, "home")
+	latency.Observe(0.5, "home")
+	latency.Observe(3, "home")
+
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP latency_seconds Request latency.\nIn seconds.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="home",le="0.1"} 1
+latency_seconds_bucket{route="home",le="1"} 2
+latency_seconds_bucket{route="home",le="+Inf"} 3
+latency_seconds_sum{route="home"} 3.55
+latency_seconds_count{route="home"} 3
+# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{route="home",code="200"} 3
+requests_total{route="say \"hi\"",code="404"} 1
+# HELP up Whether the app is up.
+# TYPE up gauge
+up 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	writeSample(&buf, "requests_total", []string{"path"}, []string{"a\\b\n\"c\"\u00a0\x7f\xff"}, "", 1)
+	// Bytes other than backslashes, quotes and newlines are written as they are
+	want := `requests_total{path="a\\b\n\"c\"` + "\u00a0\x7f\xff" + `"} 1` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounterVec("requests_total", "Number of requests.")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a metric twice to panic")
+		}
+	}()
+	reg.NewCounterVec("requests_total", "Number of requests.")
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+)
+
+// Audit remembers where each request came from, so audit events
+// created further down the chain record it even without access to
+// the request
+type Audit struct{}
+
+func (mw *Audit) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Audit) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(audit.WithRequest(r.Context(), r)))
+	})
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/i18n"
+)
+
+// LocaleCookie is the name of the cookie storing the chosen language
+const LocaleCookie = "locale"
+
+// Locale picks the language for a request and stores a localizer for
+// it in the request context. The signed in user's preference wins,
+// then the locale cookie, and then the Accept-Language header. It
+// assumes the User middleware has already been run.
+type Locale struct{}
+
+func (mw *Locale) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Locale) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var candidates []string
+		if user := context.User(r.Context()); user != nil {
+			candidates = append(candidates, user.Locale)
+		}
+		if cookie, err := r.Cookie(LocaleCookie); err == nil {
+			candidates = append(candidates, cookie.Value)
+		}
+		candidates = append(candidates, i18n.ParseAcceptLanguage(r.Header.Get("Accept-Language"))...)
+		l := i18n.New(i18n.Match(candidates...))
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", l.Locale)
+		next(w, r.WithContext(context.WithLocalizer(r.Context(), l)))
+	})
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/rand"
+)
+
+// RequestIDHeader is the header request IDs are read from and
+// returned in
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDBytes is the number of random bytes in a generated ID
+const requestIDBytes = 12
+
+// validRequestID matches the IDs we accept from clients or proxies,
+// anything else is replaced so logs can not be spoofed
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestLogger gives every request an ID, which is stored in the
+// request context and sent back in the X-Request-ID header, and
+// writes a structured log line once the request is done. Handlers
+// can log with the request ID through logging.FromContext.
+//
+// It should wrap every other middleware so the request ID is
+// available to all of them.
+type RequestLogger struct {
+	Logger *logging.Logger
+}
+
+func (mw *RequestLogger) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequestLogger) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		l := mw.Logger.With("request_id", id)
+		ctx := logging.WithRequestID(r.Context(), id)
+		ctx = logging.NewContext(ctx, l)
+		ctx = logging.WithFields(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r.WithContext(ctx))
+
+		kv := []interface{}{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.Status(),
+			"bytes", rec.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr", r.RemoteAddr,
+		}
+		fields := logging.Fields(ctx)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if fields[i+1] != nil {
+				kv = append(kv, fields[i], fields[i+1])
+			}
+		}
+		l.Info("request", kv...)
+	})
+}
+
+// newRequestID returns a random request ID, falling back to the
+// current time in the unlikely case crypto/rand fails
+func newRequestID() string {
+	id, err := rand.String(requestIDBytes)
+	if err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return id
+}
+
+// Route records the name of the route a request matched so it shows
+// up in the request log. It has to run inside the router, eg. with
+// r.Use, since the route is only known once the request is matched.
+type Route struct{}
+
+func (mw *Route) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Route) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := routeName(r); name != "" {
+			logging.SetField(r.Context(), "route", name)
+		}
+		next(w, r)
+	})
+}
+
+// statusRecorder remembers the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Status returns the status sent, which is 200 OK when the handler
+// did not write anything
+func (rec *statusRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the original writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
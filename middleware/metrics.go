@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("http_requests_total",
+		"Number of HTTP requests handled, by route, method and status code.",
+		"route", "method", "code")
+	httpDuration = metrics.NewHistogramVec("http_request_duration_seconds",
+		"Time spent handling HTTP requests, in seconds.",
+		nil, "route", "method")
+)
+
+// unmatchedRoute is the route label of requests that did not match
+// any named route, eg. 404s, so bad URLs can not create new series
+const unmatchedRoute = "unmatched"
+
+// Metrics counts requests and records their latency per named route.
+// Like Route it has to run inside the router, eg. with r.Use, and it
+// should also wrap the router's NotFoundHandler since middleware added
+// with r.Use is skipped when nothing matched.
+type Metrics struct{}
+
+func (mw *Metrics) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Metrics) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := routeName(r)
+		if route == "" {
+			route = unmatchedRoute
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+		httpDuration.ObserveSince(start, route, r.Method)
+		httpRequests.Inc(route, r.Method, strconv.Itoa(rec.Status()))
+	})
+}
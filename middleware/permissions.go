@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/vinny-sabatini/web-dev-with-go/rbac"
+)
+
+// Permissions lets the rest of the request check permissions with
+// Policy, caching them until the request is done
+type Permissions struct {
+	Policy *rbac.Policy
+}
+
+func (mw *Permissions) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Permissions) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(mw.Policy.NewContext(r.Context())))
+	})
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+// Recover catches panics in the handlers it wraps, logs them with
+// their stack trace and the request ID, and renders the 500 error
+// page so one bad request does not take down the connection. It
+// should run inside RequestLogger so the request ID is available.
+type Recover struct{}
+
+func (mw *Recover) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Recover) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// http.ErrAbortHandler is how handlers abort a response on
+			// purpose, the server already handles it quietly
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			logging.FromContext(r.Context()).Error("panic",
+				"error", fmt.Sprint(err),
+				"stack", string(debug.Stack()),
+			)
+			// Once the status is sent there is no way to replace the
+			// response, the best we can do is to stop writing to it
+			if rec.status != 0 {
+				return
+			}
+			views.Error(w, r, http.StatusInternalServerError)
+		}()
+		next(rec, r)
+	})
+}
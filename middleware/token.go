@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+)
+
+// Token will authenticate requests that send a personal access
+// token in an "Authorization: Bearer <token>" header. When the
+// header is present it always takes precedence over the cookie,
+// so a bad token leaves the request without a user.
+type Token struct {
+	models.TokenService
+	UserService models.UserService
+}
+
+func (mw *Token) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Token) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next(w, r)
+			return
+		}
+		ctx := context.WithUser(r.Context(), nil)
+		logging.SetField(ctx, "user_id", nil)
+		raw := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		if raw == header || raw == "" {
+			next(w, r.WithContext(ctx))
+			return
+		}
+		token, err := mw.TokenService.WithContext(r.Context()).Authenticate(raw)
+		if err != nil {
+			next(w, r.WithContext(ctx))
+			return
+		}
+		user, err := mw.UserService.WithContext(r.Context()).ByID(token.UserID)
+		if err != nil || user.Disabled {
+			next(w, r.WithContext(ctx))
+			return
+		}
+		logging.SetField(ctx, "user_id", user.ID)
+		ctx = context.WithUser(ctx, user)
+		ctx = context.WithToken(ctx, token)
+		next(w, r.WithContext(ctx))
+	})
+}
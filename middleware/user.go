@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/rbac"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+// User will look up the current user via their remember_token
+// cookie and, if one is found, store them in the request context.
+// Requests without a valid cookie, or from a disabled user, are
+// passed along untouched.
+type User struct {
+	models.UserService
+}
+
+func (mw *User) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("remember_token")
+		if err != nil {
+			next(w, r)
+			return
+		}
+		user, err := mw.UserService.WithContext(r.Context()).ByRemember(cookie.Value)
+		if err != nil || user.Disabled {
+			next(w, r)
+			return
+		}
+		logging.SetField(r.Context(), "user_id", user.ID)
+		ctx := context.WithUser(r.Context(), user)
+		next(w, r.WithContext(ctx))
+	})
+}
+
+// RequireUser assumes that User middleware has already been run,
+// otherwise it will never find a user and will always redirect
+// to the login page.
+//
+// Users an admin asked to reset their password are sent to the
+// password reset page until they have chosen a new one.
+type RequireUser struct {
+	URLs *urls.Builder
+}
+
+// passwordResetRoutes are the routes users who have to reset their
+// password can still use
+var passwordResetRoutes = map[string]bool{
+	"password.reset":        true,
+	"password.reset.update": true,
+	"logout":                true,
+}
+
+func (mw *RequireUser) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			mw.URLs.Redirect(w, r, "login")
+			return
+		}
+		if user.PasswordResetRequired && !passwordResetRoutes[routeName(r)] {
+			mw.URLs.Redirect(w, r, "password.reset")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// Require only lets users who have been granted Permission through.
+// Like RequireUser it sends visitors who are not signed in to the
+// login page, signed in users without the permission get a 403
+// Forbidden error page. Permissions middleware must have been run.
+type Require struct {
+	RequireUser
+	Permission string
+}
+
+// Require returns middleware that also checks the signed in user has
+// been granted permission, eg. requireUserMw.Require("users:write")
+func (mw *RequireUser) Require(permission string) *Require {
+	return &Require{
+		RequireUser: *mw,
+		Permission:  permission,
+	}
+}
+
+func (mw *Require) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Require) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return mw.RequireUser.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		if !rbac.Can(r.Context(), mw.Permission) {
+			views.Error(w, r, http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// routeName returns the name of the route r matched, if any
+func routeName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		return route.GetName()
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = `usage: web-dev-with-go migrate <command>
+
+commands:
+  up          apply every migration that has not been applied yet
+  down [n]    revert the last n migrations, 1 by default
+  status      list every migration and when it was applied
+`
+
+const dbUsage = `usage: web-dev-with-go db <command>
+
+commands:
+  reset [-yes]   drop every table and migrate from scratch, asking
+                 for confirmation unless -yes is given
+`
+
+// runMigrate manages the schema from the command line, eg.
+//
+//	web-dev-with-go migrate status
+//	web-dev-with-go migrate down 2
+//
+// Unlike the other commands it opens the database without migrating.
+func runMigrate(cfg *config, out io.Writer, args []string) error {
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[0] != "down") {
+		fmt.Fprint(out, migrateUsage)
+		return errUsage
+	}
+	services, err := cfg.openServices(false)
+	if err != nil {
+		return err
+	}
+	defer services.Close()
+
+	switch args[0] {
+	case "up":
+		pending, err := services.PendingMigrations()
+		if err != nil {
+			return err
+		}
+		if err := services.Migrate(); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied %d migrations\n", pending)
+
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		reverted, err := services.MigrateDown(steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "reverted %d migrations\n", reverted)
+
+	case "status":
+		status, err := services.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, m := range status {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", m.Version, m.Name, applied)
+		}
+		return tw.Flush()
+
+	default:
+		fmt.Fprint(out, migrateUsage)
+		return errUsage
+	}
+	return nil
+}
+
+// runDB manages the database from the command line, eg.
+//
+//	web-dev-with-go db reset
+func runDB(cfg *config, in io.Reader, out io.Writer, args []string) error {
+	if len(args) == 0 || args[0] != "reset" {
+		fmt.Fprint(out, dbUsage)
+		return errUsage
+	}
+	fs := flag.NewFlagSet("reset", flag.ContinueOnError)
+	fs.SetOutput(out)
+	yes := fs.Bool("yes", false, "Reset without asking for confirmation")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+	if !*yes {
+		fmt.Fprint(out, "This deletes every user, token, role and audit event. Type yes to continue: ")
+		answer, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if strings.TrimSpace(answer) != "yes" {
+			return fmt.Errorf("reset cancelled")
+		}
+	}
+
+	services, err := cfg.openServices(false)
+	if err != nil {
+		return err
+	}
+	defer services.Close()
+	if err := services.DestructiveReset(); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "database reset")
+	return nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"context"
+
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+)
+
+// recorder appends the events of a service to the audit trail, with
+// the actor and origin of the request the service was scoped to by
+// WithContext
+type recorder struct {
+	store audit.Store
+	ctx   context.Context
+}
+
+// event returns a new event for action on the user with userID.
+// Outside of a signed in request the user is acting on their own
+// account, eg. when signing up.
+func (rec recorder) event(action string, userID uint) *audit.Event {
+	e := audit.NewContextEvent(rec.ctx, action).Target(audit.TargetUser, userID)
+	if e.ActorID == 0 {
+		e.ActorID = userID
+	}
+	return e
+}
+
+// record appends e to the audit trail. The action was already
+// performed, so a failure is logged rather than returned.
+func (rec recorder) record(e *audit.Event) {
+	if rec.store == nil {
+		return
+	}
+	if err := rec.store.Append(e); err != nil {
+		logging.FromContext(rec.ctx).Error("unable to record audit event", "action", e.Action, "error", err)
+	}
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+)
+
+func TestUserServiceRecordsEvents(t *testing.T) {
+	events := audit.NewMemoryStore()
+	us := NewMemoryUserService(hash.NewHMAC(hmacSecretKey), events)
+
+	user := User{Name: "Jon", Email: "jon@example.com", Password: "password"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate("jon@example.com", "nope"); err != ErrInvalidPassword {
+		t.Fatalf("got %v, want ErrInvalidPassword", err)
+	}
+	if _, err := us.Authenticate("jon@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	// A failed login was not performed by anyone yet, while changes
+	// made by another signed in user are performed by them. The new
+	// remember token is part of the password change.
+	ctx := audit.WithActor(context.Background(), 42)
+	user.Password = "new password"
+	user.Remember = "new remember token"
+	if err := us.WithContext(ctx).Update(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := events.List(audit.Filter{UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range got {
+		actions = append(actions, fmt.Sprintf("%s by %d", e.Action, e.ActorID))
+	}
+	want := []string{
+		"user.password_change by 42",
+		"user.login by 1",
+		"user.login_failed by 0",
+		"user.signup by 1",
+	}
+	if fmt.Sprint(actions) != fmt.Sprint(want) {
+		t.Errorf("got events %q, want %q", actions, want)
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrIdentityTaken is returned when linking an identity that is
+	// already linked to a user
+	ErrIdentityTaken = errors.New("models: identity is already linked to a user")
+
+	_ IdentityService = &identityService{}
+	_ IdentityDB      = &identityGorm{}
+	_ IdentityDB      = &identityValidator{}
+)
+
+// Identity links a user to their account at an OAuth2 or OpenID
+// Connect provider, so they can sign in with it. Subject identifies
+// the account at the provider and never changes, unlike Email.
+type Identity struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	Provider string `gorm:"not null;unique_index:uix_identities_provider_subject"`
+	Subject  string `gorm:"not null;unique_index:uix_identities_provider_subject"`
+	// Email is the address the provider had for the user when the
+	// identity was linked, shown so users can tell identities apart
+	Email string
+}
+
+// IdentityDB is used to interact with the identities database.
+//
+// Lookups follow the same rules as UserDB: ErrNotFound is
+// returned when no identity matches.
+type IdentityDB interface {
+	ByID(id uint) (*Identity, error)
+	ByProvider(provider, subject string) (*Identity, error)
+	// ByUserID returns the identities linked to a user, oldest first
+	ByUserID(userID uint) ([]Identity, error)
+
+	Create(identity *Identity) error
+	// Delete unlinks the identity for good, so it can be linked
+	// to a user again
+	Delete(id uint) error
+}
+
+// IdentityService is a set of methods used to link users to
+// their accounts at identity providers
+type IdentityService interface {
+	// WithContext returns a copy of the service whose database
+	// queries are logged with the request ID of ctx
+	WithContext(ctx context.Context) IdentityService
+	IdentityDB
+}
+
+// NewIdentityService builds the identity service on top of the
+// provided database connection.
+func NewIdentityService(db *gorm.DB) IdentityService {
+	return &identityService{
+		IdentityDB: &identityValidator{
+			IdentityDB: &identityGorm{
+				db: db,
+			},
+		},
+		db: db,
+	}
+}
+
+type identityService struct {
+	IdentityDB
+	db *gorm.DB
+}
+
+func (is *identityService) WithContext(ctx context.Context) IdentityService {
+	return NewIdentityService(withContext(is.db, ctx))
+}
+
+type identityValidator struct {
+	IdentityDB
+}
+
+// Create will make sure the identity is complete and not linked to
+// a user yet before calling Create on the subsequent IdentityDB
+// layer.
+func (iv *identityValidator) Create(identity *Identity) error {
+	identity.Provider = strings.TrimSpace(identity.Provider)
+	identity.Subject = strings.TrimSpace(identity.Subject)
+	if identity.UserID == 0 || identity.Provider == "" || identity.Subject == "" {
+		return ErrorInvalidID
+	}
+	_, err := iv.IdentityDB.ByProvider(identity.Provider, identity.Subject)
+	switch err {
+	case nil:
+		return ErrIdentityTaken
+	case ErrNotFound:
+	default:
+		return err
+	}
+	return iv.IdentityDB.Create(identity)
+}
+
+// Delete will unlink the identity with the provided ID
+func (iv *identityValidator) Delete(id uint) error {
+	if id == 0 {
+		return ErrorInvalidID
+	}
+	return iv.IdentityDB.Delete(id)
+}
+
+type identityGorm struct {
+	db *gorm.DB
+}
+
+// ByID will look up an identity by its ID
+func (ig *identityGorm) ByID(id uint) (*Identity, error) {
+	var identity Identity
+	err := first(ig.db.Where("id = ?", id), &identity)
+	return &identity, err
+}
+
+// ByProvider will look up the identity with subject at provider
+func (ig *identityGorm) ByProvider(provider, subject string) (*Identity, error) {
+	var identity Identity
+	err := first(ig.db.Where("provider = ? AND subject = ?", provider, subject), &identity)
+	return &identity, err
+}
+
+// ByUserID returns every identity linked to a user, oldest first
+func (ig *identityGorm) ByUserID(userID uint) ([]Identity, error) {
+	var identities []Identity
+	err := ig.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+// Create will store the provided identity
+func (ig *identityGorm) Create(identity *Identity) error {
+	return ig.db.Create(identity).Error
+}
+
+// Delete will remove the identity with the provided ID. It is not
+// soft deleted, since that would keep it in the unique index.
+func (ig *identityGorm) Delete(id uint) error {
+	return ig.db.Unscoped().Delete(&Identity{Model: gorm.Model{ID: id}}).Error
+}
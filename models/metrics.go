@@ -0,0 +1,10 @@
+package models
+
+import "github.com/vinny-sabatini/web-dev-with-go/metrics"
+
+// bcryptDuration tracks how long hashing and comparing passwords
+// takes, so we notice when bcrypt.DefaultCost becomes too slow
+var bcryptDuration = metrics.NewHistogramVec("bcrypt_duration_seconds",
+	"Time spent hashing and comparing passwords with bcrypt, in seconds.",
+	[]float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	"op")
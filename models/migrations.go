@@ -0,0 +1,306 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migration is a versioned change to the database schema. Versions
+// only ever increase and a migration must never be edited once it
+// has shipped, add a new one instead.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(db *gorm.DB) error
+	Down    func(db *gorm.DB) error
+}
+
+// schemaMigration records a migration that was applied
+type schemaMigration struct {
+	Version   int `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migrations is every migration of the app, in order. The first one
+// creates the users and tokens tables AutoMigrate used to create,
+// without the columns the later ones add, so existing databases are
+// brought under version control without changes. Tables are created
+// from the snapshots in migrations_tables.go.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users_and_tokens",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&usersV1{}, &tokensV1{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&tokensV1{}, &usersV1{}).Error
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_users_admin_flags",
+		// Columns are added with IF NOT EXISTS, since migration 1
+		// used to create them from the User model on new databases
+		Up: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS admin boolean NOT NULL DEFAULT false,
+				ADD COLUMN IF NOT EXISTS disabled boolean NOT NULL DEFAULT false,
+				ADD COLUMN IF NOT EXISTS password_reset_required boolean NOT NULL DEFAULT false`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE users
+				DROP COLUMN IF EXISTS admin,
+				DROP COLUMN IF EXISTS disabled,
+				DROP COLUMN IF EXISTS password_reset_required`).Error
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_audit_events",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&auditEventsV3{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&auditEventsV3{}).Error
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_users_email_verified_at",
+		Up: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS email_verified_at timestamp with time zone`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE users
+				DROP COLUMN IF EXISTS email_verified_at`).Error
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_roles",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&rolesV5{}, &userRolesV5{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&userRolesV5{}, &rolesV5{}).Error
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_audit_events_details",
+		Up: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE audit_events
+				ADD COLUMN IF NOT EXISTS details text`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE audit_events
+				DROP COLUMN IF EXISTS details`).Error
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_users_email_active_index",
+		// Deleted users keep their row until they are purged, so
+		// email addresses are only unique among the other users
+		Up: func(db *gorm.DB) error {
+			return db.Exec(`DROP INDEX IF EXISTS uix_users_email;
+				CREATE UNIQUE INDEX IF NOT EXISTS uix_users_email_active
+				ON users (email) WHERE deleted_at IS NULL`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Exec(`DROP INDEX IF EXISTS uix_users_email_active;
+				CREATE UNIQUE INDEX IF NOT EXISTS uix_users_email ON users (email)`).Error
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_identities",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&identitiesV8{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&identitiesV8{}).Error
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_users_locale",
+		// Like migration 2, migration 1 used to create the column
+		Up: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS locale text`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE users
+				DROP COLUMN IF EXISTS locale`).Error
+		},
+	},
+}
+
+// MigrationStatus is a migration and whether it has been applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// migrationLockKey is the key of the Postgres advisory lock held
+// while migrating, so replicas starting at the same time do not
+// apply the same migrations twice
+const migrationLockKey = 7304581926
+
+// Migrate applies every migration that has not been applied yet, in
+// order. Each migration runs in its own transaction.
+func (s *Services) Migrate() error {
+	return s.withMigrationLock(func() error {
+		applied, err := s.appliedMigrations()
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			tx := s.db.Begin()
+			if err := m.Up(tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("models: migration %d %s: %w", m.Version, m.Name, err)
+			}
+			record := schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}
+			if err := tx.Create(&record).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err := tx.Commit().Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the last steps migrations that were applied,
+// newest first, and returns how many it reverted. Each migration
+// runs in its own transaction.
+func (s *Services) MigrateDown(steps int) (int, error) {
+	reverted := 0
+	err := s.withMigrationLock(func() error {
+		applied, err := s.appliedMigrations()
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			tx := s.db.Begin()
+			if err := m.Down(tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("models: reverting migration %d %s: %w", m.Version, m.Name, err)
+			}
+			if err := tx.Delete(&schemaMigration{Version: m.Version}).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err := tx.Commit().Error; err != nil {
+				return err
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// withMigrationLock creates the schema_migrations table if needed and
+// runs fn while holding the migration lock. Advisory locks belong to
+// a database session, so the lock is taken on a connection of its
+// own rather than one of the pool fn uses.
+func (s *Services) withMigrationLock(fn func() error) error {
+	ctx := context.Background()
+	conn, err := s.db.DB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("models: locking migrations: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	if err := s.db.AutoMigrate(&schemaMigration{}).Error; err != nil {
+		return err
+	}
+	return fn()
+}
+
+// MigrationStatus returns every migration and whether it has been
+// applied to the database
+func (s *Services) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		ret[i].Migration = m
+		if at, ok := applied[m.Version]; ok {
+			ret[i].Applied = true
+			ret[i].AppliedAt = &at
+		}
+	}
+	return ret, nil
+}
+
+// PendingMigrations returns the number of migrations that have not
+// been applied yet, the schema is current when it is 0. Like
+// MigrationStatus it only reads the database, so it is safe to call
+// from health checks.
+func (s *Services) PendingMigrations() (int, error) {
+	status, err := s.MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, m := range status {
+		if !m.Applied {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// appliedMigrations returns when each applied migration was applied,
+// keyed by version. Nothing was applied to a database without the
+// schema_migrations table yet.
+func (s *Services) appliedMigrations() (map[int]time.Time, error) {
+	// HasTable would hide why the database could not be read
+	var tables int
+	err := s.db.Raw("SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = ?",
+		schemaMigration{}.TableName()).Row().Scan(&tables)
+	if err != nil {
+		return nil, err
+	}
+	if tables == 0 {
+		return map[int]time.Time{}, nil
+	}
+	var records []schemaMigration
+	if err := s.db.Order("version").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time, len(records))
+	for _, r := range records {
+		applied[r.Version] = r.AppliedAt
+	}
+	return applied, nil
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The tables migrations create, as they were when each migration
+// shipped. Migrations must not use the models themselves, or the
+// schema they create would change whenever a model does.
+
+// usersV1 is the users table of migration 1
+type usersV1 struct {
+	gorm.Model
+	Name         string
+	Email        string `gorm:"not null;unique_index"`
+	PasswordHash string `gorm:"not null"`
+	RememberHash string `gorm:"not null;unique_index"`
+}
+
+func (usersV1) TableName() string {
+	return "users"
+}
+
+// tokensV1 is the tokens table of migration 1
+type tokensV1 struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	Scopes     string
+	TokenHash  string `gorm:"not null;unique_index"`
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+}
+
+func (tokensV1) TableName() string {
+	return "tokens"
+}
+
+// auditEventsV3 is the audit_events table of migration 3
+type auditEventsV3 struct {
+	ID         uint `gorm:"primary_key"`
+	CreatedAt  time.Time
+	ActorID    uint   `gorm:"index"`
+	Action     string `gorm:"not null;index"`
+	TargetType string
+	TargetID   uint `gorm:"index"`
+	Details    string
+	IP         string
+	UserAgent  string
+	RequestID  string
+}
+
+func (auditEventsV3) TableName() string {
+	return "audit_events"
+}
+
+// rolesV5 is the roles table of migration 5
+type rolesV5 struct {
+	ID          uint `gorm:"primary_key"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Name        string `gorm:"not null;unique_index"`
+	Description string
+	Permissions string
+}
+
+func (rolesV5) TableName() string {
+	return "roles"
+}
+
+// userRolesV5 is the user_roles table of migration 5
+type userRolesV5 struct {
+	UserID    uint `gorm:"primary_key;auto_increment:false"`
+	RoleID    uint `gorm:"primary_key;auto_increment:false;index"`
+	CreatedAt time.Time
+}
+
+func (userRolesV5) TableName() string {
+	return "user_roles"
+}
+
+// identitiesV8 is the identities table of migration 8
+type identitiesV8 struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	Provider string `gorm:"not null;unique_index:uix_identities_provider_subject"`
+	Subject  string `gorm:"not null;unique_index:uix_identities_provider_subject"`
+	Email    string
+}
+
+func (identitiesV8) TableName() string {
+	return "identities"
+}
@@ -0,0 +1,315 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrRoleNameRequired is returned when creating a role without a name
+	ErrRoleNameRequired = errors.New("models: role name is required")
+
+	// ErrRoleNameInvalid is returned for a role name that is not made
+	// of lowercase letters, digits, dashes and underscores
+	ErrRoleNameInvalid = errors.New("models: role name is invalid")
+
+	// ErrRoleNameTaken is returned when another role already has the name
+	ErrRoleNameTaken = errors.New("models: role name is already taken")
+
+	// ErrInvalidPermission is returned for a permission that is not
+	// written as "resource:action"
+	ErrInvalidPermission = errors.New("models: invalid permission")
+
+	_ RoleService = &roleService{}
+	_ RoleDB      = &roleGorm{}
+	_ RoleDB      = &roleValidator{}
+)
+
+const (
+	// PermUsersRead allows looking up other users and their audit trail
+	PermUsersRead = "users:read"
+	// PermUsersWrite allows disabling, signing out and deleting other users
+	PermUsersWrite = "users:write"
+	// PermRolesManage allows changing roles and who they are assigned to
+	PermRolesManage = "roles:manage"
+	// PermAuditRead allows searching the audit trail of every user
+	PermAuditRead = "audit:read"
+)
+
+// Permissions lists the permissions the site checks for. Roles may
+// be granted other permissions too, for features that are still to
+// come.
+var Permissions = []string{PermUsersRead, PermUsersWrite, PermRolesManage, PermAuditRead}
+
+var (
+	roleNameRegex   = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	permissionRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*:[a-z][a-z0-9_]*$`)
+)
+
+// Role is a named set of permissions that can be assigned to users
+type Role struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Name        string `gorm:"not null;unique_index"`
+	Description string
+
+	// Permissions is a space separated list of the permissions
+	// granted by this role, eg. "users:read users:write"
+	Permissions string
+}
+
+// PermissionList returns the permissions granted by the role
+func (r *Role) PermissionList() []string {
+	return strings.Fields(r.Permissions)
+}
+
+// HasPermission reports whether the role grants permission
+func (r *Role) HasPermission(permission string) bool {
+	for _, p := range strings.Fields(r.Permissions) {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// UserRole assigns a role to a user
+type UserRole struct {
+	UserID    uint `gorm:"primary_key;auto_increment:false"`
+	RoleID    uint `gorm:"primary_key;auto_increment:false;index"`
+	CreatedAt time.Time
+}
+
+// RoleDB is used to interact with the roles database.
+//
+// Lookups follow the same rules as UserDB: ErrNotFound is
+// returned when no role matches.
+type RoleDB interface {
+	ByID(id uint) (*Role, error)
+	ByName(name string) (*Role, error)
+	// All returns every role, ordered by name
+	All() ([]Role, error)
+
+	Create(role *Role) error
+	Update(role *Role) error
+	// Delete removes the role, unassigning it from every user
+	Delete(id uint) error
+
+	// ByUserID returns the roles assigned to a user, ordered by name
+	ByUserID(userID uint) ([]Role, error)
+	// Members returns the users a role is assigned to
+	Members(roleID uint) ([]User, error)
+	// Assign and Unassign give a role to a user or take it away.
+	// Both do nothing if the user already has or does not have
+	// the role.
+	Assign(userID, roleID uint) error
+	Unassign(userID, roleID uint) error
+}
+
+// RoleService is a set of methods used to manage roles and
+// the permissions they grant users
+type RoleService interface {
+	// Permissions returns every permission granted to a user by
+	// their roles
+	Permissions(userID uint) ([]string, error)
+
+	// WithContext returns a copy of the service whose database
+	// queries are logged with the request ID of ctx
+	WithContext(ctx context.Context) RoleService
+	RoleDB
+}
+
+// NewRoleService builds the role service on top of the provided
+// database connection.
+func NewRoleService(db *gorm.DB) RoleService {
+	return &roleService{
+		RoleDB: &roleValidator{
+			RoleDB: &roleGorm{
+				db: db,
+			},
+		},
+		db: db,
+	}
+}
+
+type roleService struct {
+	RoleDB
+	db *gorm.DB
+}
+
+func (rs *roleService) WithContext(ctx context.Context) RoleService {
+	return NewRoleService(withContext(rs.db, ctx))
+}
+
+// Permissions returns the union of the permissions of every role
+// assigned to the user, sorted
+func (rs *roleService) Permissions(userID uint) ([]string, error) {
+	roles, err := rs.ByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var ret []string
+	for _, role := range roles {
+		for _, p := range strings.Fields(role.Permissions) {
+			if !seen[p] {
+				seen[p] = true
+				ret = append(ret, p)
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+type roleValidator struct {
+	RoleDB
+}
+
+// ByName will normalize the name before calling ByName on the
+// subsequent RoleDB layer.
+func (rv *roleValidator) ByName(name string) (*Role, error) {
+	return rv.RoleDB.ByName(normalizeRoleName(name))
+}
+
+// Create will validate the role before calling Create on the
+// subsequent RoleDB layer.
+func (rv *roleValidator) Create(role *Role) error {
+	if err := rv.validate(role); err != nil {
+		return err
+	}
+	return rv.RoleDB.Create(role)
+}
+
+// Update will validate the role before calling Update on the
+// subsequent RoleDB layer.
+func (rv *roleValidator) Update(role *Role) error {
+	if err := rv.validate(role); err != nil {
+		return err
+	}
+	return rv.RoleDB.Update(role)
+}
+
+// Delete will delete the role with the provided ID
+func (rv *roleValidator) Delete(id uint) error {
+	if id == 0 {
+		return ErrorInvalidID
+	}
+	return rv.RoleDB.Delete(id)
+}
+
+// validate normalizes the name and permissions of role, making sure
+// they are valid and that no other role has the same name
+func (rv *roleValidator) validate(role *Role) error {
+	role.Name = normalizeRoleName(role.Name)
+	if role.Name == "" {
+		return ErrRoleNameRequired
+	}
+	if !roleNameRegex.MatchString(role.Name) {
+		return ErrRoleNameInvalid
+	}
+	existing, err := rv.RoleDB.ByName(role.Name)
+	switch {
+	case err == nil && existing.ID != role.ID:
+		return ErrRoleNameTaken
+	case err != nil && err != ErrNotFound:
+		return err
+	}
+	role.Description = strings.TrimSpace(role.Description)
+
+	perms := strings.Fields(strings.ToLower(role.Permissions))
+	sort.Strings(perms)
+	var unique []string
+	for i, p := range perms {
+		if !permissionRegex.MatchString(p) {
+			return ErrInvalidPermission
+		}
+		if i == 0 || p != perms[i-1] {
+			unique = append(unique, p)
+		}
+	}
+	role.Permissions = strings.Join(unique, " ")
+	return nil
+}
+
+func normalizeRoleName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+type roleGorm struct {
+	db *gorm.DB
+}
+
+func (rg *roleGorm) ByID(id uint) (*Role, error) {
+	var role Role
+	err := first(rg.db.Where("id = ?", id), &role)
+	return &role, err
+}
+
+func (rg *roleGorm) ByName(name string) (*Role, error) {
+	var role Role
+	err := first(rg.db.Where("name = ?", name), &role)
+	return &role, err
+}
+
+func (rg *roleGorm) All() ([]Role, error) {
+	var roles []Role
+	err := rg.db.Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (rg *roleGorm) Create(role *Role) error {
+	return rg.db.Create(role).Error
+}
+
+func (rg *roleGorm) Update(role *Role) error {
+	return rg.db.Save(role).Error
+}
+
+// Delete removes the assignments of the role and the role itself in
+// a single transaction
+func (rg *roleGorm) Delete(id uint) error {
+	return rg.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", id).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Role{ID: id}).Error
+	})
+}
+
+func (rg *roleGorm) ByUserID(userID uint) ([]Role, error) {
+	var roles []Role
+	err := rg.db.
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Find(&roles).Error
+	return roles, err
+}
+
+func (rg *roleGorm) Members(roleID uint) ([]User, error) {
+	var users []User
+	err := rg.db.
+		Joins("JOIN user_roles ON user_roles.user_id = users.id").
+		Where("user_roles.role_id = ?", roleID).
+		Order("users.id").
+		Find(&users).Error
+	return users, err
+}
+
+func (rg *roleGorm) Assign(userID, roleID uint) error {
+	return rg.db.Exec(`INSERT INTO user_roles (user_id, role_id, created_at)
+		VALUES (?, ?, ?) ON CONFLICT DO NOTHING`, userID, roleID, time.Now()).Error
+}
+
+func (rg *roleGorm) Unassign(userID, roleID uint) error {
+	return rg.db.Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&UserRole{}).Error
+}
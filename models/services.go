@@ -0,0 +1,68 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jinzhu/gorm"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+)
+
+// Services holds every service in the models package so they
+// can share a single database connection.
+type Services struct {
+	User     UserService
+	Token    TokenService
+	Role     RoleService
+	Identity IdentityService
+	Audit    audit.Store
+	db       *gorm.DB
+}
+
+// NewServices opens a database connection using connectionInfo
+// and builds every service on top of it.
+func NewServices(connectionInfo string) (*Services, error) {
+	db, err := gorm.Open("postgres", connectionInfo)
+	if err != nil {
+		return nil, err
+	}
+	db.LogMode(true)
+	db.SetLogger(logging.GormLogger{Logger: logging.Default()})
+	hmac := hash.NewHMAC(hmacSecretKey)
+	return &Services{
+		User:     NewUserService(db, hmac),
+		Token:    NewTokenService(db, hmac),
+		Role:     NewRoleService(db),
+		Identity: NewIdentityService(db),
+		Audit:    audit.NewGormStore(db),
+		db:       db,
+	}, nil
+}
+
+// DBStats returns the statistics of the database connection pool
+func (s *Services) DBStats() sql.DBStats {
+	return s.db.DB().Stats()
+}
+
+// Close closes the database connection shared by the services
+func (s *Services) Close() error {
+	return s.db.Close()
+}
+
+// DestructiveReset drops all tables and migrates them from scratch
+func (s *Services) DestructiveReset() error {
+	if err := s.db.DropTableIfExists(&audit.Event{}, &Identity{}, &UserRole{}, &Role{}, &Token{}, &User{}, &schemaMigration{}).Error; err != nil {
+		return err
+	}
+	return s.Migrate()
+}
+
+// withContext returns a copy of db that logs SQL statements with the
+// logger of ctx, so they carry the ID of the request that ran them
+func withContext(db *gorm.DB, ctx context.Context) *gorm.DB {
+	scoped := db.New()
+	scoped.SetLogger(logging.GormLogger{Logger: logging.FromContext(ctx)})
+	return scoped
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+	"github.com/vinny-sabatini/web-dev-with-go/rand"
+)
+
+var (
+	// ErrTokenExpired is returned when authenticating with a token past its ExpiresAt
+	ErrTokenExpired = errors.New("models: token has expired")
+
+	// ErrTokenNameRequired is returned when creating a token without a name
+	ErrTokenNameRequired = errors.New("models: token name is required")
+
+	// ErrInvalidScope is returned when a token is created with a scope we do not know about
+	ErrInvalidScope = errors.New("models: invalid token scope")
+
+	_ TokenService = &tokenService{}
+	_ TokenDB      = &tokenGorm{}
+	_ TokenDB      = &tokenValidator{}
+)
+
+const (
+	// ScopeRead allows a token to read data for its user
+	ScopeRead = "read"
+	// ScopeWrite allows a token to change data for its user
+	ScopeWrite = "write"
+)
+
+// TokenScopes lists every scope a personal access token may be granted
+var TokenScopes = []string{ScopeRead, ScopeWrite}
+
+// Token represents a personal access token a user created
+// for programmatic access to the API. Only the HMAC hash of
+// the token is stored, the raw Token is only available right
+// after it is created.
+type Token struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index"`
+	Name   string `gorm:"not null"`
+
+	// Scopes is a space separated list of scopes granted to this token
+	Scopes string
+
+	Token     string `gorm:"-" json:",omitempty"`
+	TokenHash string `gorm:"not null;unique_index" json:"-"`
+
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+}
+
+// HasScope reports whether the token was granted scope
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range strings.Fields(t.Scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the token has an expiry in the past
+func (t *Token) Expired() bool {
+	return t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now())
+}
+
+// TokenDB is used to interact with the tokens database.
+//
+// Lookups follow the same rules as UserDB: ErrNotFound is
+// returned when no token matches.
+type TokenDB interface {
+	ByID(id uint) (*Token, error)
+	ByToken(token string) (*Token, error)
+	ByUserID(userID uint) ([]Token, error)
+
+	Create(token *Token) error
+	Touch(token *Token) error
+	Delete(id uint) error
+}
+
+// TokenService is a set of methods used to manipulate and
+// work with personal access tokens
+type TokenService interface {
+	// Authenticate will look up the provided raw token and make
+	// sure it has not expired, recording that it was just used.
+	// It returns ErrNotFound or ErrTokenExpired if the token is
+	// not valid, or another error if something goes wrong.
+	Authenticate(token string) (*Token, error)
+
+	// WithContext returns a copy of the service whose database
+	// queries are logged with the request ID of ctx
+	WithContext(ctx context.Context) TokenService
+	TokenDB
+}
+
+// NewTokenService builds the token service on top of the provided
+// database connection. Tokens are hashed with hmac before storing.
+func NewTokenService(db *gorm.DB, hmac hash.HMAC) TokenService {
+	return &tokenService{
+		TokenDB: &tokenValidator{
+			hmac: hmac,
+			TokenDB: &tokenGorm{
+				db: db,
+			},
+		},
+		db:   db,
+		hmac: hmac,
+	}
+}
+
+type tokenService struct {
+	TokenDB
+	db   *gorm.DB
+	hmac hash.HMAC
+}
+
+func (ts *tokenService) WithContext(ctx context.Context) TokenService {
+	return NewTokenService(withContext(ts.db, ctx), ts.hmac)
+}
+
+func (ts *tokenService) Authenticate(token string) (*Token, error) {
+	found, err := ts.ByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if found.Expired() {
+		return nil, ErrTokenExpired
+	}
+	if err := ts.Touch(found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+type tokenValidator struct {
+	TokenDB
+	hmac hash.HMAC
+}
+
+// ByToken will hash the raw token and then call ByToken
+// on the subsequent TokenDB layer.
+func (tv *tokenValidator) ByToken(token string) (*Token, error) {
+	return tv.TokenDB.ByToken(tv.hmac.Hash(token))
+}
+
+// Create will validate the token, generate the raw token value
+// if one was not provided and then store its hash.
+func (tv *tokenValidator) Create(token *Token) error {
+	token.Name = strings.TrimSpace(token.Name)
+	if token.Name == "" {
+		return ErrTokenNameRequired
+	}
+	if token.UserID == 0 {
+		return ErrorInvalidID
+	}
+	for _, scope := range strings.Fields(token.Scopes) {
+		if !validScope(scope) {
+			return ErrInvalidScope
+		}
+	}
+	if token.Token == "" {
+		raw, err := rand.APIToken()
+		if err != nil {
+			return err
+		}
+		token.Token = raw
+	}
+	token.TokenHash = tv.hmac.Hash(token.Token)
+	return tv.TokenDB.Create(token)
+}
+
+// Delete will revoke the token with the provided ID
+func (tv *tokenValidator) Delete(id uint) error {
+	if id == 0 {
+		return ErrorInvalidID
+	}
+	return tv.TokenDB.Delete(id)
+}
+
+func validScope(scope string) bool {
+	for _, s := range TokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenGorm struct {
+	db *gorm.DB
+}
+
+// ByID will look up a token by its ID
+func (tg *tokenGorm) ByID(id uint) (*Token, error) {
+	var token Token
+	err := first(tg.db.Where("id = ?", id), &token)
+	return &token, err
+}
+
+// ByToken looks up a token with the given hash. This method
+// expects the token to already be hashed.
+func (tg *tokenGorm) ByToken(tokenHash string) (*Token, error) {
+	var token Token
+	err := first(tg.db.Where("token_hash = ?", tokenHash), &token)
+	return &token, err
+}
+
+// ByUserID returns every token belonging to a user, newest first
+func (tg *tokenGorm) ByUserID(userID uint) ([]Token, error) {
+	var tokens []Token
+	err := tg.db.Where("user_id = ?", userID).Order("created_at desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// Create will store the provided token
+func (tg *tokenGorm) Create(token *Token) error {
+	return tg.db.Create(token).Error
+}
+
+// Touch records that the token was just used. UpdateColumn is
+// used so UpdatedAt is left alone.
+func (tg *tokenGorm) Touch(token *Token) error {
+	now := time.Now()
+	token.LastUsedAt = &now
+	return tg.db.Model(token).UpdateColumn("last_used_at", now).Error
+}
+
+// Delete will delete the token with the provided ID
+func (tg *tokenGorm) Delete(id uint) error {
+	token := Token{Model: gorm.Model{ID: id}}
+	return tg.db.Delete(&token).Error
+}
@@ -1,10 +1,16 @@
 package models
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
 	"github.com/vinny-sabatini/web-dev-with-go/hash"
 	"github.com/vinny-sabatini/web-dev-with-go/rand"
 
@@ -21,12 +27,29 @@ var (
 	// ErrInvalidPassword is returned if while authenticating an email is found, but the password does not match
 	ErrInvalidPassword = errors.New("models: incorrect password provided")
 
+	// ErrUserDisabled is returned when authenticating a user an admin has disabled
+	ErrUserDisabled = errors.New("models: user is disabled")
+
+	// ErrEmailRequired is returned when a user is saved without an email address
+	ErrEmailRequired = errors.New("models: email address is required")
+
+	// ErrEmailInvalid is returned for an email address that does not look like one
+	ErrEmailInvalid = errors.New("models: email address is not valid")
+
+	// ErrEmailTaken is returned when another user already has the email address
+	ErrEmailTaken = errors.New("models: email address is already taken")
+
 	// Ensure our types properly impelment their corresponding interfaces (do not compile if they do not)
 	_ UserService = &userService{}
 	_ UserDB      = &userGorm{}
 	_ UserDB      = &userValidator{}
+	_ UserDB      = &userMemory{}
 )
 
+// emailRegex only catches obvious typos, the email address is proven
+// to work by verifying it
+var emailRegex = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[a-z]{2,}$`)
+
 const userPwPepper = "lets-go-red-wings"
 const hmacSecretKey = "go-green-go-white"
 
@@ -36,18 +59,43 @@ const hmacSecretKey = "go-green-go-white"
 // access to their content.
 type User struct {
 	gorm.Model
-	Name  string
-	Email string `gorm:"not null;unique_index"`
+	Name string
+	// Email is unique among users that have not been deleted, see
+	// the add_users_email_active_index migration
+	Email string `gorm:"not null"`
+
+	// Locale is the language the user prefers the site in, eg. "es"
+	Locale string
+
+	// EmailVerifiedAt is when the user proved they own their email
+	// address, nil until they do
+	EmailVerifiedAt *time.Time
+
+	// Admin users can manage other users
+	Admin bool `gorm:"not null;default:false"`
+	// Disabled users can not sign in, set by an admin
+	Disabled bool `gorm:"not null;default:false"`
+	// PasswordResetRequired makes the user choose a new password
+	// the next time they sign in, set by an admin
+	PasswordResetRequired bool `gorm:"not null;default:false"`
 
 	// `gorm:"-"` is to ensure gorm does NOT store this in the DB
-	Password string `gorm:"-"`
+	// `json:"-"` keeps secrets out of views rendered as JSON
+	Password string `gorm:"-" json:"-"`
 
 	// User has to have a Password hash (or we couldn't auth)
 	// This can also cause issues if you try to auto-migrate DB
-	PasswordHash string `gorm:"not null"`
+	PasswordHash string `gorm:"not null" json:"-"`
 
-	Remember     string `gorm:"-"`
-	RememberHash string `gorm:"not null;unique_index"`
+	Remember     string `gorm:"-" json:"-"`
+	RememberHash string `gorm:"not null;unique_index" json:"-"`
+}
+
+// HasPassword reports whether the user can sign in with a password.
+// Users who signed up with an identity provider have none until they
+// choose one.
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != ""
 }
 
 // UserDB is used to interact with the users database.
@@ -64,18 +112,32 @@ type UserDB interface {
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
 	ByRemember(token string) (*User, error)
+	// DeletedByID looks up a user that was deleted and has not been
+	// purged yet
+	DeletedByID(id uint) (*User, error)
+
+	// Query returns a page of the users matching q, see UserQuery
+	Query(q UserQuery) (*UserPage, error)
 
 	// Methods for altering users
 	Create(user *User) error
 	Update(user *User) error
 	Delete(id uint) error
 
+	// Purge permanently removes the users that were deleted before
+	// the provided time, along with their tokens, roles and
+	// identities, and returns their IDs
+	Purge(before time.Time) ([]uint, error)
+	// Restore undoes the delete of a user that has not been purged
+	// yet. It returns ErrNotFound if there is no such deleted user
+	// and ErrEmailTaken if another user has their email address now.
+	Restore(id uint) error
+
 	// Used to close a DB connection
 	Close() error
 
-	// Migration helpers
-	AutoMigrate() error
-	DestructiveReset() error
+	// Ping checks that the database can still be reached
+	Ping() error
 }
 
 // UserService is a set of methods used to manipulate and work with
@@ -86,28 +148,67 @@ type UserService interface {
 	// email will be returned, otherwise you will receive either:
 	// ErrNotFound, ErrInvalidPassword, or another error if something goes wrong.
 	Authenticate(email, password string) (*User, error)
+
+	// CheckPassword returns ErrInvalidPassword if password is not
+	// the password of user, eg. to confirm a sensitive change
+	CheckPassword(user *User, password string) error
+
+	// ChangePassword sets a new password for user after checking
+	// their current one, returning ErrInvalidPassword if it does not
+	// match. The remember token is rotated too, signing the user out
+	// on every other device; sign the current one back in with the
+	// new user.Remember.
+	ChangePassword(user *User, current, password string) error
+
+	// WithContext returns a copy of the service whose database
+	// queries are logged with the request ID of ctx
+	WithContext(ctx context.Context) UserService
 	UserDB
 }
 
-func NewUserService(connectionInfo string) (UserService, error) {
-	ug, err := newUserGorm(connectionInfo)
-	if err != nil {
-		return nil, err
+// NewUserService builds the user service on top of the provided
+// database connection. Remember tokens are hashed with hmac, and
+// security relevant changes are recorded in the audit_events table.
+func NewUserService(db *gorm.DB, hmac hash.HMAC) UserService {
+	return newGormUserService(db, hmac, context.Background())
+}
+
+func newGormUserService(db *gorm.DB, hmac hash.HMAC, ctx context.Context) UserService {
+	ug := &userGorm{
+		db: db,
+	}
+	rec := recorder{
+		store: audit.NewGormStore(db),
+		ctx:   ctx,
 	}
-	hmac := hash.NewHMAC(hmacSecretKey)
+	return newUserService(ug, hmac, rec, func(ctx context.Context) UserService {
+		return newGormUserService(withContext(db, ctx), hmac, ctx)
+	})
+}
+
+// newUserService wraps db with the validator and service layers.
+// withContext builds the service returned by WithContext.
+func newUserService(db UserDB, hmac hash.HMAC, rec recorder, withContext func(context.Context) UserService) *userService {
 	uv := &userValidator{
 		hmac:   hmac,
-		UserDB: ug,
+		rec:    rec,
+		UserDB: db,
 	}
 	return &userService{
-		UserDB: &userValidator{
-			UserDB: uv,
-		},
-	}, nil
+		UserDB:      uv,
+		rec:         rec,
+		withContext: withContext,
+	}
 }
 
 type userService struct {
 	UserDB
+	rec         recorder
+	withContext func(context.Context) UserService
+}
+
+func (us *userService) WithContext(ctx context.Context) UserService {
+	return us.withContext(ctx)
 }
 
 // Authenticate can be used to authenticate a user with a provided email address and password
@@ -115,29 +216,83 @@ type userService struct {
 //   nil, ErrNotFound
 // If the password is invalid, this will return
 //   nil, ErrInvalidPassword
+// If the user has been disabled, this will return
+//   nil, ErrUserDisabled
 // If the email and password are both valid, this will return
 //   user, nil
 // If another error is encountered, this will return
 //   nil, error
+//
+// Logins and failed logins are recorded in the audit trail.
 func (us *userService) Authenticate(email, password string) (*User, error) {
 	foundUser, err := us.ByEmail(email)
 	if err != nil {
+		if err == ErrNotFound {
+			us.loginFailed(0, email, "unknown_email")
+		}
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+userPwPepper))
+	err = us.CheckPassword(foundUser, password)
 	if err != nil {
 		switch err {
-		case bcrypt.ErrMismatchedHashAndPassword:
+		case ErrInvalidPassword:
+			us.loginFailed(foundUser.ID, email, "invalid_password")
 			return nil, ErrInvalidPassword
 		default:
 			return nil, err
 		}
 	}
+	if foundUser.Disabled {
+		us.loginFailed(foundUser.ID, email, "disabled")
+		return nil, ErrUserDisabled
+	}
 
+	us.rec.record(us.rec.event(audit.ActionLogin, foundUser.ID))
 	return foundUser, nil
 }
 
+// CheckPassword compares password with the password hash of user.
+// Users without a password never match.
+func (us *userService) CheckPassword(user *User, password string) error {
+	if !user.HasPassword() {
+		return ErrInvalidPassword
+	}
+	start := time.Now()
+	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password+userPwPepper))
+	bcryptDuration.ObserveSince(start, "compare")
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrInvalidPassword
+	}
+	return err
+}
+
+// ChangePassword checks the current password of user before saving
+// the new one along with a new remember token. Users without a
+// password choose their first one without it.
+func (us *userService) ChangePassword(user *User, current, password string) error {
+	if user.HasPassword() {
+		if err := us.CheckPassword(user, current); err != nil {
+			return err
+		}
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	user.Password = password
+	user.Remember = token
+	return us.Update(user)
+}
+
+// loginFailed records a failed login for the user with userID, 0 if
+// there is no user with the email, and why it failed
+func (us *userService) loginFailed(userID uint, email, reason string) {
+	e := audit.NewContextEvent(us.rec.ctx, audit.ActionLoginFailed).Target(audit.TargetUser, userID)
+	e.Details = reason + " " + email
+	us.rec.record(e)
+}
+
 type userValidatorFunc func(*User) error
 
 func runUserValidatorFunctions(user *User, functions ...userValidatorFunc) error {
@@ -152,6 +307,13 @@ func runUserValidatorFunctions(user *User, functions ...userValidatorFunc) error
 type userValidator struct {
 	UserDB
 	hmac hash.HMAC
+	rec  recorder
+}
+
+// ByEmail will normalize the email address before calling ByEmail
+// on the subsequent UserDB layer.
+func (uv *userValidator) ByEmail(email string) (*User, error) {
+	return uv.UserDB.ByEmail(normalizeEmail(email))
 }
 
 // ByRemember will hash the remember token and then call ByRemember
@@ -161,10 +323,16 @@ func (uv *userValidator) ByRemember(token string) (*User, error) {
 	return uv.UserDB.ByRemember(rememberHash)
 }
 
-// Create will handle hashing the password and then call the
-// subsequent
+// Create will validate the email address and hash the password
+// and then call the subsequent
 func (uv *userValidator) Create(user *User) error {
-	if err := runUserValidatorFunctions(user, uv.bcryptPassword); err != nil {
+	err := runUserValidatorFunctions(user,
+		uv.bcryptPassword,
+		uv.normalizeEmail,
+		uv.requireEmail,
+		uv.emailFormat,
+		uv.emailIsAvail)
+	if err != nil {
 		return err
 	}
 
@@ -176,19 +344,83 @@ func (uv *userValidator) Create(user *User) error {
 		user.Remember = token
 	}
 	user.RememberHash = uv.hmac.Hash(user.Remember)
-	return uv.UserDB.Create(user)
+	if err := uv.UserDB.Create(user); err != nil {
+		return err
+	}
+	uv.rec.record(uv.rec.event(audit.ActionSignup, user.ID))
+	return nil
 }
 
-// Update will hash a remember token if it is provided.
+// Update will validate the email address and hash a remember token
+// if it is provided. A new email address has to be verified again.
+// Changing the email address or the password is recorded in the audit
+// trail. New remember tokens are not, since they are part of the
+// login, logout or admin action that is recorded instead.
 func (uv *userValidator) Update(user *User) error {
-	if err := runUserValidatorFunctions(user, uv.bcryptPassword); err != nil {
+	passwordChanged := user.Password != ""
+	err := runUserValidatorFunctions(user,
+		uv.bcryptPassword,
+		uv.normalizeEmail,
+		uv.requireEmail,
+		uv.emailFormat,
+		uv.emailIsAvail)
+	if err != nil {
 		return err
 	}
+	existing, err := uv.UserDB.ByID(user.ID)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	emailChanged := err == nil && existing.Email != user.Email
+	if emailChanged {
+		user.EmailVerifiedAt = nil
+	}
 
+	rememberHash := ""
 	if user.Remember != "" {
-		user.RememberHash = uv.hmac.Hash(user.Remember)
+		rememberHash = uv.hmac.Hash(user.Remember)
+	}
+	if rememberHash != "" {
+		user.RememberHash = rememberHash
+	}
+	if err := uv.UserDB.Update(user); err != nil {
+		return err
+	}
+	if emailChanged {
+		e := uv.rec.event(audit.ActionEmailChange, user.ID)
+		e.Details = existing.Email + " " + user.Email
+		uv.rec.record(e)
+	}
+	if passwordChanged {
+		uv.rec.record(uv.rec.event(audit.ActionPasswordChange, user.ID))
 	}
-	return uv.UserDB.Update(user)
+	return nil
+}
+
+// Query will clean up the filters and make sure the sort, cursor
+// and limit are valid before calling Query on the subsequent
+// UserDB layer.
+func (uv *userValidator) Query(q UserQuery) (*UserPage, error) {
+	q.Search = strings.TrimSpace(q.Search)
+	q.Name = strings.TrimSpace(q.Name)
+	q.Email = strings.TrimSpace(q.Email)
+	switch q.Sort {
+	case "":
+		q.Sort = SortCreated
+	case SortCreated, SortUpdated:
+	default:
+		return nil, ErrInvalidSort
+	}
+	if _, err := q.decodeCursor(); err != nil {
+		return nil, err
+	}
+	if q.Limit < 1 {
+		q.Limit = DefaultQueryLimit
+	}
+	if q.Limit > MaxQueryLimit {
+		q.Limit = MaxQueryLimit
+	}
+	return uv.UserDB.Query(q)
 }
 
 // Delete will delete the user with the provided ID
@@ -196,7 +428,33 @@ func (uv *userValidator) Delete(id uint) error {
 	if id == 0 {
 		return ErrorInvalidID
 	}
-	return uv.UserDB.Delete(id)
+	if err := uv.UserDB.Delete(id); err != nil {
+		return err
+	}
+	uv.rec.record(uv.rec.event(audit.ActionDelete, id))
+	return nil
+}
+
+// Restore will restore the deleted user with the provided ID
+func (uv *userValidator) Restore(id uint) error {
+	if id == 0 {
+		return ErrorInvalidID
+	}
+	if err := uv.UserDB.Restore(id); err != nil {
+		return err
+	}
+	uv.rec.record(audit.NewContextEvent(uv.rec.ctx, audit.ActionRestore).Target(audit.TargetUser, id))
+	return nil
+}
+
+// Purge will permanently remove the users deleted before the
+// provided time, recording each of them in the audit trail
+func (uv *userValidator) Purge(before time.Time) ([]uint, error) {
+	ids, err := uv.UserDB.Purge(before)
+	for _, id := range ids {
+		uv.rec.record(audit.NewContextEvent(uv.rec.ctx, audit.ActionPurge).Target(audit.TargetUser, id))
+	}
+	return ids, err
 }
 
 // bcryptPassword will hash a users password with a predefined pepper (userPwPepper)
@@ -206,7 +464,9 @@ func (uv *userValidator) bcryptPassword(user *User) error {
 		return nil
 	}
 	pwBytes := []byte(user.Password + userPwPepper)
+	start := time.Now()
 	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	bcryptDuration.ObserveSince(start, "hash")
 	if err != nil {
 		return err
 	}
@@ -215,15 +475,42 @@ func (uv *userValidator) bcryptPassword(user *User) error {
 	return nil
 }
 
-func newUserGorm(connectionInfo string) (*userGorm, error) {
-	db, err := gorm.Open("postgres", connectionInfo)
-	db.LogMode(true)
-	if err != nil {
-		return nil, err
+// normalizeEmail trims and lowercases the email address of user
+func (uv *userValidator) normalizeEmail(user *User) error {
+	user.Email = normalizeEmail(user.Email)
+	return nil
+}
+
+func (uv *userValidator) requireEmail(user *User) error {
+	if user.Email == "" {
+		return ErrEmailRequired
 	}
-	return &userGorm{
-		db: db,
-	}, nil
+	return nil
+}
+
+func (uv *userValidator) emailFormat(user *User) error {
+	if !emailRegex.MatchString(user.Email) {
+		return ErrEmailInvalid
+	}
+	return nil
+}
+
+// emailIsAvail makes sure no other user has the email address of user
+func (uv *userValidator) emailIsAvail(user *User) error {
+	existing, err := uv.UserDB.ByEmail(user.Email)
+	switch {
+	case err == ErrNotFound:
+		return nil
+	case err != nil:
+		return err
+	case existing.ID != user.ID:
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 type userGorm struct {
@@ -241,6 +528,14 @@ func (ug *userGorm) ByID(id uint) (*User, error) {
 	return &user, err
 }
 
+// DeletedByID looks up a deleted user that has not been purged yet
+func (ug *userGorm) DeletedByID(id uint) (*User, error) {
+	var user User
+	db := ug.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id)
+	err := first(db, &user)
+	return &user, err
+}
+
 // ByEmail looks up a user with a given email
 // And will return that user if found.
 // If a user is found, we will not return an error
@@ -264,6 +559,76 @@ func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
 	return &user, err
 }
 
+// Query will count the users matching the filters of q and then
+// look up the page after the cursor. One extra user is fetched to
+// know whether there is a next page.
+func (ug *userGorm) Query(q UserQuery) (*UserPage, error) {
+	db := ug.db.Model(&User{})
+	if q.Deleted {
+		db = ug.db.Unscoped().Model(&User{}).Where("deleted_at IS NOT NULL")
+	}
+	if q.Search != "" {
+		pattern := likePattern(q.Search)
+		db = db.Where("LOWER(name) LIKE ? OR LOWER(email) LIKE ?", pattern, pattern)
+	}
+	if q.Name != "" {
+		db = db.Where("LOWER(name) LIKE ?", likePattern(q.Name))
+	}
+	if q.Email != "" {
+		db = db.Where("LOWER(email) LIKE ?", likePattern(q.Email))
+	}
+	if q.Verified != nil {
+		if *q.Verified {
+			db = db.Where("email_verified_at IS NOT NULL")
+		} else {
+			db = db.Where("email_verified_at IS NULL")
+		}
+	}
+	if q.Disabled != nil {
+		db = db.Where("disabled = ?", *q.Disabled)
+	}
+
+	page := &UserPage{}
+	if err := db.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	column, op, dir := q.sortColumn(), ">", "ASC"
+	if q.Desc {
+		op, dir = "<", "DESC"
+	}
+	cursor, err := q.decodeCursor()
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil {
+		db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, op), time.Unix(0, cursor.Time), cursor.ID)
+	}
+	err = db.Order(fmt.Sprintf("%s %s, id %s", column, dir, dir)).
+		Limit(q.Limit + 1).
+		Find(&page.Users).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Users) > q.Limit {
+		page.Users = page.Users[:q.Limit]
+		page.NextCursor = q.encodeCursor(&page.Users[q.Limit-1])
+	}
+	return page, nil
+}
+
+// likePattern returns a LIKE pattern matching values that contain s,
+// ignoring case
+func likePattern(s string) string {
+	return "%" + escapeLike(strings.ToLower(s)) + "%"
+}
+
+// escapeLike escapes the characters LIKE treats specially, so they
+// are matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 // Create will create the provided user and backfill data
 // like the ID, CreatedAt, and UpdatedAt fields
 // This will return the error if there is one
@@ -283,25 +648,63 @@ func (ug *userGorm) Delete(id uint) error {
 	return ug.db.Delete(user).Error
 }
 
-// Closes the userGorm database connection
-func (ug *userGorm) Close() error {
-	return ug.db.Close()
+// Restore will clear the deleted_at of the user with the provided
+// ID, checking their email address is still free in the same
+// transaction
+func (ug *userGorm) Restore(id uint) error {
+	return ug.db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := first(tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id), &user); err != nil {
+			return err
+		}
+		var taken int
+		if err := tx.Model(&User{}).Where("email = ?", user.Email).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrEmailTaken
+		}
+		return tx.Unscoped().Model(&user).Update("deleted_at", nil).Error
+	})
 }
 
-// DestructiveReset drops and recreates the User table
-func (ug *userGorm) DestructiveReset() error {
-	if err := ug.db.DropTableIfExists(&User{}).Error; err != nil {
-		return err
+// Purge will hard delete the users soft deleted before the provided
+// time, their tokens, role assignments and identities in one
+// transaction
+func (ug *userGorm) Purge(before time.Time) ([]uint, error) {
+	var ids []uint
+	err := ug.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Model(&User{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id IN (?)", ids).Delete(&Token{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id IN (?)", ids).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id IN (?)", ids).Delete(&Identity{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id IN (?)", ids).Delete(&User{}).Error
+	})
+	if err != nil {
+		return nil, err
 	}
-	return ug.AutoMigrate()
+	return ids, nil
 }
 
-// AutoMigrate will attempt to automatically migrate the users table
-func (ug *userGorm) AutoMigrate() error {
-	if err := ug.db.AutoMigrate(&User{}).Error; err != nil {
-		return err
-	}
-	return nil
+// Closes the userGorm database connection
+func (ug *userGorm) Close() error {
+	return ug.db.Close()
+}
+
+// Ping checks that the database connection is alive
+func (ug *userGorm) Ping() error {
+	return ug.db.DB().Ping()
 }
 
 // first will query using the provided gorm.DB and will
@@ -0,0 +1,317 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/audit"
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+)
+
+// ErrDuplicateUser is returned by the in-memory UserDB when a user
+// would share their email or remember hash with another user, like
+// the unique indexes of the users table
+var ErrDuplicateUser = errors.New("models: email or remember token already in use")
+
+// NewMemoryUserService builds the user service on top of an in-memory
+// UserDB, recording security relevant changes in events. It is meant
+// for tests and local development, everything is lost when the
+// process exits.
+func NewMemoryUserService(hmac hash.HMAC, events audit.Store) UserService {
+	return newMemoryUserService(&userMemory{}, hmac, events, context.Background())
+}
+
+func newMemoryUserService(um *userMemory, hmac hash.HMAC, events audit.Store, ctx context.Context) UserService {
+	rec := recorder{
+		store: events,
+		ctx:   ctx,
+	}
+	return newUserService(um, hmac, rec, func(ctx context.Context) UserService {
+		return newMemoryUserService(um, hmac, events, ctx)
+	})
+}
+
+// userMemory is a UserDB that keeps users in a slice. It mirrors
+// userGorm: deleted users are soft deleted and ignored by every
+// lookup, and times are stored with the microsecond precision of
+// postgres, so both backends return the same results.
+type userMemory struct {
+	mu     sync.Mutex
+	users  []User
+	nextID uint
+}
+
+// ByID will look up a user by a given ID
+func (um *userMemory) ByID(id uint) (*User, error) {
+	return um.find(func(u *User) bool { return u.ID == id })
+}
+
+// DeletedByID will look up a deleted user that has not been purged
+func (um *userMemory) DeletedByID(id uint) (*User, error) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	if i := um.index(id); i >= 0 && um.users[i].DeletedAt != nil {
+		user := copyUser(&um.users[i])
+		return &user, nil
+	}
+	return nil, ErrNotFound
+}
+
+// ByEmail will look up a user by a given email
+func (um *userMemory) ByEmail(email string) (*User, error) {
+	return um.find(func(u *User) bool { return u.Email == email })
+}
+
+// ByRemember will look up a user by a given remember token hash
+func (um *userMemory) ByRemember(rememberHash string) (*User, error) {
+	return um.find(func(u *User) bool { return u.RememberHash == rememberHash })
+}
+
+// Query works like userGorm.Query, filtering and sorting every user
+// that has not been deleted, or only deleted users when q.Deleted
+// is set
+func (um *userMemory) Query(q UserQuery) (*UserPage, error) {
+	cursor, err := q.decodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	um.mu.Lock()
+	var users []User
+	for i := range um.users {
+		if u := &um.users[i]; (u.DeletedAt != nil) == q.Deleted && q.matches(u) {
+			users = append(users, copyUser(u))
+		}
+	}
+	um.mu.Unlock()
+
+	// before reports whether a sorts before b
+	before := func(a, b *User) bool {
+		at, bt := q.sortTime(a), q.sortTime(b)
+		if !at.Equal(bt) {
+			if q.Desc {
+				return at.After(bt)
+			}
+			return at.Before(bt)
+		}
+		if q.Desc {
+			return a.ID > b.ID
+		}
+		return a.ID < b.ID
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return before(&users[i], &users[j])
+	})
+
+	page := &UserPage{Total: len(users)}
+	if cursor != nil {
+		last := &User{}
+		last.ID = cursor.ID
+		last.CreatedAt = time.Unix(0, cursor.Time)
+		last.UpdatedAt = last.CreatedAt
+		i := sort.Search(len(users), func(i int) bool {
+			return before(last, &users[i])
+		})
+		users = users[i:]
+	}
+	if len(users) > q.Limit {
+		users = users[:q.Limit]
+		page.NextCursor = q.encodeCursor(&users[q.Limit-1])
+	}
+	page.Users = users
+	return page, nil
+}
+
+// matches reports whether u passes the filters of q
+func (q UserQuery) matches(u *User) bool {
+	contains := func(s, substr string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+	}
+	if q.Search != "" && !contains(u.Name, q.Search) && !contains(u.Email, q.Search) {
+		return false
+	}
+	if q.Name != "" && !contains(u.Name, q.Name) {
+		return false
+	}
+	if q.Email != "" && !contains(u.Email, q.Email) {
+		return false
+	}
+	if q.Verified != nil && u.Verified() != *q.Verified {
+		return false
+	}
+	if q.Disabled != nil && u.Disabled != *q.Disabled {
+		return false
+	}
+	return true
+}
+
+// Create will store the provided user and backfill data like the
+// ID, CreatedAt, and UpdatedAt fields
+func (um *userMemory) Create(user *User) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	return um.create(user)
+}
+
+// create stores user, um.mu must be held
+func (um *userMemory) create(user *User) error {
+	if err := um.checkUnique(user); err != nil {
+		return err
+	}
+	now := memoryNow()
+	if user.ID == 0 {
+		um.nextID++
+		user.ID = um.nextID
+	} else if user.ID > um.nextID {
+		um.nextID = user.ID
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	um.users = append(um.users, copyUser(user))
+	return nil
+}
+
+// Update will replace the stored user with the provided user, or
+// create it if it does not exist yet, like gorm's Save
+func (um *userMemory) Update(user *User) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	i := um.index(user.ID)
+	if i < 0 {
+		return um.create(user)
+	}
+	if err := um.checkUnique(user); err != nil {
+		return err
+	}
+	user.UpdatedAt = memoryNow()
+	um.users[i] = copyUser(user)
+	return nil
+}
+
+// Delete will soft delete the user with the provided ID
+func (um *userMemory) Delete(id uint) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	if i := um.index(id); i >= 0 && um.users[i].DeletedAt == nil {
+		now := memoryNow()
+		um.users[i].DeletedAt = &now
+	}
+	return nil
+}
+
+// Restore will undo the soft delete of the user with the provided
+// ID, unless another user took their email address in the meantime
+func (um *userMemory) Restore(id uint) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	i := um.index(id)
+	if i < 0 || um.users[i].DeletedAt == nil {
+		return ErrNotFound
+	}
+	for j := range um.users {
+		if u := &um.users[j]; u.DeletedAt == nil && u.Email == um.users[i].Email {
+			return ErrEmailTaken
+		}
+	}
+	um.users[i].DeletedAt = nil
+	um.users[i].UpdatedAt = memoryNow()
+	return nil
+}
+
+// Purge will remove the users soft deleted before the provided time.
+// There are no tokens, roles or identities to remove with them.
+func (um *userMemory) Purge(before time.Time) ([]uint, error) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	var ids []uint
+	kept := um.users[:0]
+	for _, u := range um.users {
+		if u.DeletedAt != nil && u.DeletedAt.Before(before) {
+			ids = append(ids, u.ID)
+			continue
+		}
+		kept = append(kept, u)
+	}
+	um.users = kept
+	return ids, nil
+}
+
+// Close does nothing, there is no connection to close
+func (um *userMemory) Close() error {
+	return nil
+}
+
+// Ping always succeeds
+func (um *userMemory) Ping() error {
+	return nil
+}
+
+// find returns a copy of the first user that has not been deleted
+// and matches fn, or ErrNotFound
+func (um *userMemory) find(fn func(*User) bool) (*User, error) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	for i := range um.users {
+		if u := &um.users[i]; u.DeletedAt == nil && fn(u) {
+			user := copyUser(u)
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// index returns the position of the user with id, including deleted
+// users, or -1. um.mu must be held.
+func (um *userMemory) index(id uint) int {
+	for i := range um.users {
+		if um.users[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkUnique returns ErrDuplicateUser if another user has the email
+// or remember hash of user. Like the indexes of the users table,
+// deleted users only count for the remember hash. um.mu must be held.
+func (um *userMemory) checkUnique(user *User) error {
+	for i := range um.users {
+		u := &um.users[i]
+		if u.ID == user.ID {
+			continue
+		}
+		if (u.DeletedAt == nil && u.Email == user.Email) || u.RememberHash == user.RememberHash {
+			return ErrDuplicateUser
+		}
+	}
+	return nil
+}
+
+// memoryNow returns the current time rounded to the precision
+// postgres stores timestamps with
+func memoryNow() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// copyUser returns a copy of u that shares no pointers with it, so
+// callers can not change stored users without calling Update
+func copyUser(u *User) User {
+	user := *u
+	user.Password = ""
+	user.Remember = ""
+	if u.DeletedAt != nil {
+		t := *u.DeletedAt
+		user.DeletedAt = &t
+	}
+	if u.EmailVerifiedAt != nil {
+		t := *u.EmailVerifiedAt
+		user.EmailVerifiedAt = &t
+	}
+	return user
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInvalidCursor is returned by Query when UserQuery.After
+	// is not a cursor returned by an earlier query with the same sort
+	ErrInvalidCursor = errors.New("models: invalid cursor")
+
+	// ErrInvalidSort is returned by Query for an unknown UserQuery.Sort
+	ErrInvalidSort = errors.New("models: invalid sort")
+)
+
+// UserSort is the field users are sorted by in a query
+type UserSort string
+
+const (
+	SortCreated UserSort = "created"
+	SortUpdated UserSort = "updated"
+)
+
+const (
+	// DefaultQueryLimit is used when UserQuery.Limit is not set
+	DefaultQueryLimit = 25
+	// MaxQueryLimit is the largest page Query will return
+	MaxQueryLimit = 100
+)
+
+// UserQuery filters, sorts and paginates the users returned by Query.
+// Zero values match every user.
+type UserQuery struct {
+	// Search matches users whose name or email contains it,
+	// Name and Email only match that field. All of them ignore case.
+	Search string
+	Name   string
+	Email  string
+
+	// Verified and Disabled only match users in that state when set
+	Verified *bool
+	Disabled *bool
+
+	// Deleted lists the users that were deleted and have not been
+	// purged yet, instead of every other user
+	Deleted bool
+
+	// Sort is the field to sort by, SortCreated by default, and Desc
+	// sorts newest first. Users with the same time are sorted by ID.
+	Sort UserSort
+	Desc bool
+
+	// After is the NextCursor of the previous page, empty for the
+	// first page. Limit is the number of users on a page.
+	After string
+	Limit int
+}
+
+// UserPage is a page of users returned by Query
+type UserPage struct {
+	Users []User
+	// Total is the number of users matching the filters across
+	// every page
+	Total int
+	// NextCursor is passed as UserQuery.After to get the next page,
+	// it is empty on the last page
+	NextCursor string
+}
+
+// userCursor is the position of the last user on a page. It is sent
+// to clients base64 encoded, so it is opaque to them.
+type userCursor struct {
+	Sort UserSort `json:"s"`
+	Desc bool     `json:"d"`
+	Time int64    `json:"t"`
+	ID   uint     `json:"i"`
+}
+
+// sortTime returns the value of the field q sorts by
+func (q UserQuery) sortTime(u *User) time.Time {
+	if q.Sort == SortUpdated {
+		return u.UpdatedAt
+	}
+	return u.CreatedAt
+}
+
+// sortColumn returns the column q sorts by
+func (q UserQuery) sortColumn() string {
+	if q.Sort == SortUpdated {
+		return "updated_at"
+	}
+	return "created_at"
+}
+
+// encodeCursor returns the cursor pointing after u
+func (q UserQuery) encodeCursor(u *User) string {
+	b, _ := json.Marshal(userCursor{
+		Sort: q.Sort,
+		Desc: q.Desc,
+		Time: q.sortTime(u).UnixNano(),
+		ID:   u.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses q.After, making sure it was created for the
+// same sort order
+func (q UserQuery) decodeCursor() (*userCursor, error) {
+	if q.After == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(q.After)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c userCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != q.Sort || c.Desc != q.Desc {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Verified reports whether the user has verified their email address
+func (u *User) Verified() bool {
+	return u.EmailVerifiedAt != nil
+}
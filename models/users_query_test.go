@@ -0,0 +1,136 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+)
+
+// testQueryUsers runs the same queries against us, so every UserDB
+// backend is held to the same results
+func testQueryUsers(t *testing.T, us UserService) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	verified := start.Add(time.Hour)
+	for i := 1; i <= 7; i++ {
+		user := User{
+			Name:     fmt.Sprintf("User %d", i),
+			Email:    fmt.Sprintf("user%d@example.com", i),
+			Password: "password",
+			Disabled: i%3 == 0,
+		}
+		// Users 4 and 5 share a created time, so ties are sorted by ID
+		user.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		if i == 5 {
+			user.CreatedAt = start.Add(4 * time.Minute)
+		}
+		if i%2 == 0 {
+			user.EmailVerifiedAt = &verified
+		}
+		if err := us.Create(&user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := us.Delete(7); err != nil {
+		t.Fatal(err)
+	}
+
+	yes, no := true, false
+	tests := []struct {
+		name string
+		q    UserQuery
+		want []uint
+	}{
+		{"all", UserQuery{}, []uint{1, 2, 3, 4, 5, 6}},
+		{"desc", UserQuery{Desc: true}, []uint{6, 5, 4, 3, 2, 1}},
+		{"search", UserQuery{Search: "USER1"}, []uint{1}},
+		{"name", UserQuery{Name: "user 2"}, []uint{2}},
+		{"email", UserQuery{Email: "example"}, []uint{1, 2, 3, 4, 5, 6}},
+		{"like is escaped", UserQuery{Email: "user_"}, nil},
+		{"verified", UserQuery{Verified: &yes}, []uint{2, 4, 6}},
+		{"unverified", UserQuery{Verified: &no}, []uint{1, 3, 5}},
+		{"disabled", UserQuery{Disabled: &yes}, []uint{3, 6}},
+		{"enabled and verified", UserQuery{Disabled: &no, Verified: &yes}, []uint{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total := queryAll(t, us, tt.q)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got users %v, want %v", got, tt.want)
+			}
+			if total != len(tt.want) {
+				t.Errorf("got total %d, want %d", total, len(tt.want))
+			}
+		})
+	}
+
+	t.Run("sort updated", func(t *testing.T) {
+		user, err := us.ByID(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := us.Update(user); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := queryAll(t, us, UserQuery{Sort: SortUpdated, Desc: true})
+		if len(got) == 0 || got[0] != 2 {
+			t.Errorf("got users %v, want user 2 first", got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := us.Query(UserQuery{Sort: "name"}); err != ErrInvalidSort {
+			t.Errorf("got %v, want ErrInvalidSort", err)
+		}
+		if _, err := us.Query(UserQuery{After: "nope"}); err != ErrInvalidCursor {
+			t.Errorf("got %v, want ErrInvalidCursor", err)
+		}
+		page, err := us.Query(UserQuery{Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := UserQuery{Desc: true, After: page.NextCursor}
+		if _, err := us.Query(q); err != ErrInvalidCursor {
+			t.Errorf("got %v, want ErrInvalidCursor for a cursor of another sort", err)
+		}
+	})
+}
+
+// queryAll follows the cursors of q two users at a time, returning
+// the IDs of every user and the total of the first page
+func queryAll(t *testing.T, us UserService, q UserQuery) ([]uint, int) {
+	t.Helper()
+	q.Limit = 2
+	var ids []uint
+	total := -1
+	for {
+		page, err := us.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total < 0 {
+			total = page.Total
+		}
+		for _, u := range page.Users {
+			ids = append(ids, u.ID)
+		}
+		if page.NextCursor == "" {
+			return ids, total
+		}
+		q.After = page.NextCursor
+	}
+}
+
+func TestQueryUsersMemory(t *testing.T) {
+	testQueryUsers(t, NewMemoryUserService(hash.NewHMAC(hmacSecretKey), nil))
+}
+
+func TestQueryUsersGorm(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Skipf("postgres is not available: %s", err)
+	}
+	defer us.Close()
+	testQueryUsers(t, us)
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"testing"
 	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
 )
 
-func testingUserService() (*UserService, error) {
+func testingUserService() (UserService, error) {
 	const (
 		host = "localhost"
 		port = 5432
@@ -20,21 +22,21 @@ func testingUserService() (*UserService, error) {
 		dbname   = "postgres"
 	)
 	psqlInfo := fmt.Sprintf("host=%s port=%d password=%s user=%s dbname=%s sslmode=disable", host, port, password, user, dbname)
-	us, err := NewUserService(psqlInfo)
+	services, err := NewServices(psqlInfo)
 	if err != nil {
 		return nil, err
 	}
 
-	us.db.LogMode(false)
-	us.DestructiveReset()
+	services.db.LogMode(false)
+	services.DestructiveReset()
 
-	return us, nil
+	return services.User, nil
 }
 
 func TestCreateUser(t *testing.T) {
 	us, err := testingUserService()
 	if err != nil {
-		t.Fatal(err)
+		t.Skipf("postgres is not available: %s", err)
 	}
 	users := []User{{
 		Name:  "Vinny",
@@ -48,7 +50,7 @@ func TestCreateUser(t *testing.T) {
 			t.Fatal(err)
 		}
 	}
-	getUser, err := us.ById(1)
+	getUser, err := us.ByID(1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -59,7 +61,7 @@ func TestCreateUser(t *testing.T) {
 		t.Fatalf("Expected CreatedAt to be recent, got %s", time.Since(getUser.CreatedAt))
 	}
 
-	_, err = us.ById(100)
+	_, err = us.ByID(100)
 	if err != ErrNotFound {
 		t.Fatalf("Expected ErrNotFound, got %s", err)
 	}
@@ -87,8 +89,174 @@ func TestCreateUser(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = us.ById(1)
+	_, err = us.ByID(1)
 	if err != ErrNotFound {
 		t.Fatal(err)
 	}
 }
+
+func TestUserEmailValidation(t *testing.T) {
+	us := NewMemoryUserService(hash.NewHMAC(hmacSecretKey), nil)
+	jon := User{Name: "Jon", Email: " Jon@Example.com ", Password: "password"}
+	if err := us.Create(&jon); err != nil {
+		t.Fatal(err)
+	}
+	if jon.Email != "jon@example.com" {
+		t.Errorf("got email %q, want it normalized", jon.Email)
+	}
+	if _, err := us.ByEmail("JON@example.com"); err != nil {
+		t.Errorf("got %v looking up a differently cased email", err)
+	}
+
+	tests := []struct {
+		email string
+		want  error
+	}{
+		{"", ErrEmailRequired},
+		{"jon", ErrEmailInvalid},
+		{"jon@example", ErrEmailInvalid},
+		{"JON@example.com", ErrEmailTaken},
+	}
+	for _, tt := range tests {
+		user := User{Name: "Other", Email: tt.email, Password: "password"}
+		if err := us.Create(&user); err != tt.want {
+			t.Errorf("Create with %q: got %v, want %v", tt.email, err, tt.want)
+		}
+	}
+
+	// Changing the email address has to be verified again
+	now := time.Now()
+	jon.EmailVerifiedAt = &now
+	if err := us.Update(&jon); err != nil {
+		t.Fatal(err)
+	}
+	jon.Email = "jon@example.org"
+	if err := us.Update(&jon); err != nil {
+		t.Fatal(err)
+	}
+	got, err := us.ByID(jon.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "jon@example.org" || got.EmailVerifiedAt != nil {
+		t.Errorf("got email %q verified at %v, want jon@example.org unverified", got.Email, got.EmailVerifiedAt)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	us := NewMemoryUserService(hash.NewHMAC(hmacSecretKey), nil)
+	user := User{Name: "Jon", Email: "jon@example.com", Password: "password"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	oldRemember := user.Remember
+
+	if err := us.ChangePassword(&user, "wrong", "new password"); err != ErrInvalidPassword {
+		t.Fatalf("got %v, want ErrInvalidPassword", err)
+	}
+	if err := us.ChangePassword(&user, "password", "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.ByRemember(oldRemember); err != ErrNotFound {
+		t.Errorf("got %v for the old remember token, want ErrNotFound", err)
+	}
+	if _, err := us.ByRemember(user.Remember); err != nil {
+		t.Errorf("got %v for the new remember token", err)
+	}
+	if _, err := us.Authenticate("jon@example.com", "new password"); err != nil {
+		t.Errorf("got %v signing in with the new password", err)
+	}
+}
+
+func TestUserWithoutPassword(t *testing.T) {
+	us := NewMemoryUserService(hash.NewHMAC(hmacSecretKey), nil)
+	user := User{Name: "Ada", Email: "ada@example.com"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.HasPassword() {
+		t.Fatal("a user created without a password has one")
+	}
+	if _, err := us.Authenticate("ada@example.com", ""); err != ErrInvalidPassword {
+		t.Errorf("got %v signing in without a password, want ErrInvalidPassword", err)
+	}
+
+	// The first password is chosen without a current one
+	if err := us.ChangePassword(&user, "", "first password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate("ada@example.com", "first password"); err != nil {
+		t.Errorf("got %v signing in with the first password", err)
+	}
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	us := NewMemoryUserService(hash.NewHMAC(hmacSecretKey), nil)
+	for i := 1; i <= 3; i++ {
+		user := User{Name: "User", Email: fmt.Sprintf("user%d@example.com", i), Password: "password"}
+		if err := us.Create(&user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := us.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Users deleted after the cutoff are still in their grace period
+	ids, err := us.Purge(time.Now().Add(-time.Hour))
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("got %v, %v purging before the user was deleted", ids, err)
+	}
+	ids, err = us.Purge(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != "[1]" {
+		t.Errorf("got purged users %v, want [1]", ids)
+	}
+	// Once purged, the email address can be used again
+	user := User{Name: "User", Email: "user1@example.com", Password: "password"}
+	if err := us.Create(&user); err != nil {
+		t.Errorf("got %v reusing the email address of a purged user", err)
+	}
+}
+
+func TestRestoreDeletedUser(t *testing.T) {
+	us := NewMemoryUserService(hash.NewHMAC(hmacSecretKey), nil)
+	jon := User{Name: "Jon", Email: "jon@example.com", Password: "password"}
+	if err := us.Create(&jon); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Restore(jon.ID); err != ErrNotFound {
+		t.Errorf("got %v restoring a user that was not deleted, want ErrNotFound", err)
+	}
+	if err := us.Delete(jon.ID); err != nil {
+		t.Fatal(err)
+	}
+	page, err := us.Query(UserQuery{Deleted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Users) != 1 || page.Users[0].ID != jon.ID {
+		t.Errorf("got deleted users %v, want only jon", page.Users)
+	}
+
+	// The email address of a deleted user can be signed up with again,
+	// which stops the deleted user from being restored
+	other := User{Name: "Other Jon", Email: "jon@example.com", Password: "password"}
+	if err := us.Create(&other); err != nil {
+		t.Fatalf("got %v signing up with the email address of a deleted user", err)
+	}
+	if err := us.Restore(jon.ID); err != ErrEmailTaken {
+		t.Errorf("got %v, want ErrEmailTaken", err)
+	}
+	if err := us.Delete(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Restore(jon.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.ByEmail("jon@example.com"); err != nil {
+		t.Errorf("got %v looking up the restored user", err)
+	}
+}
@@ -0,0 +1,260 @@
+// Package oauth signs users in with OAuth2 and OpenID Connect
+// providers, like GitHub and Google, using the authorization code
+// flow with PKCE. It only depends on the standard library.
+package oauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/vinny-sabatini/web-dev-with-go/rand"
+)
+
+var (
+	// ErrDenied is returned when the user did not allow signing in,
+	// eg. because they pressed cancel at the provider
+	ErrDenied = errors.New("oauth: access denied")
+
+	// ErrStateMismatch is returned when the state of a callback does
+	// not match the one the flow was started with
+	ErrStateMismatch = errors.New("oauth: state mismatch")
+
+	// ErrInvalidIDToken is returned when the ID token of an OpenID
+	// Connect provider can not be verified
+	ErrInvalidIDToken = errors.New("oauth: invalid ID token")
+)
+
+// maxResponseBody limits how much of a provider response we read
+const maxResponseBody = 1 << 20
+
+// Identity is the account at a provider a user signed in with
+type Identity struct {
+	// Provider is the Name of the provider
+	Provider string
+	// Subject identifies the account at the provider, it never
+	// changes even when the email address does
+	Subject string
+	Email   string
+	// EmailVerified is whether the provider checked that the user
+	// owns Email
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an OAuth2 or OpenID Connect provider users can sign
+// in with. Providers that set Issuer are OpenID Connect providers,
+// whose ID tokens are verified with the keys at JWKSURL. Use the
+// presets, eg. GitHub, or OIDC and Discover to build one.
+type Provider struct {
+	// Name identifies the provider in URLs and stored identities,
+	// eg. "github"
+	Name string
+	// DisplayName is shown to users, eg. "GitHub"
+	DisplayName string
+
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+
+	Issuer  string
+	JWKSURL string
+
+	// Client makes the requests to the provider, http.DefaultClient
+	// when nil
+	Client *http.Client
+
+	// identity looks up the identity of an access token for
+	// providers that are not OpenID Connect providers
+	identity func(ctx context.Context, p *Provider, accessToken string) (*Identity, error)
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// State is what a flow has to remember between sending the user to
+// the provider and handling the callback. It is usually kept in a
+// short lived cookie.
+type State struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewState returns a random State for a new flow
+func NewState() (*State, error) {
+	var values [3]string
+	for i := range values {
+		b, err := rand.Bytes(32)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &State{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// AuthCodeURL returns the URL of the provider to send the user to.
+// The provider sends them back to redirectURL with a code.
+func (p *Provider) AuthCodeURL(redirectURL string, s *State) string {
+	challenge := sha256.Sum256([]byte(s.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {s.State},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if p.Issuer != "" {
+		q.Set("nonce", s.Nonce)
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + q.Encode()
+}
+
+// Callback handles the query the provider sent the user back to
+// redirectURL with. It checks it against the State the flow was
+// started with, exchanges the code for tokens and returns who the
+// user is at the provider.
+func (p *Provider) Callback(ctx context.Context, redirectURL string, s *State, query url.Values) (*Identity, error) {
+	if e := query.Get("error"); e != "" {
+		if e == "access_denied" {
+			return nil, ErrDenied
+		}
+		return nil, fmt.Errorf("oauth: %s: %s %s", p.Name, e, query.Get("error_description"))
+	}
+	if s == nil || s.State == "" || query.Get("state") != s.State {
+		return nil, ErrStateMismatch
+	}
+	tok, err := p.exchange(ctx, redirectURL, query.Get("code"), s)
+	if err != nil {
+		return nil, err
+	}
+
+	var ident *Identity
+	switch {
+	case p.identity != nil:
+		ident, err = p.identity(ctx, p, tok.AccessToken)
+	case p.Issuer != "":
+		ident, err = p.verifyIDToken(ctx, tok.IDToken, s.Nonce)
+		if err == nil && ident.Email == "" && p.UserInfoURL != "" {
+			err = p.userInfo(ctx, tok.AccessToken, ident)
+		}
+	default:
+		err = fmt.Errorf("oauth: %s: no way to look up the user", p.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ident.Provider = p.Name
+	return ident, nil
+}
+
+// tokenResponse is the response of a token endpoint
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchange trades code for the tokens of the user
+func (p *Provider) exchange(ctx context.Context, redirectURL, code string, s *State) (*tokenResponse, error) {
+	if code == "" {
+		return nil, fmt.Errorf("oauth: %s: callback without a code", p.Name)
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {s.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var tok tokenResponse
+	// Token endpoints report errors in the body, sometimes with a 200
+	if err := p.do(req, &tok); err != nil && tok.Error == "" {
+		return nil, err
+	}
+	if tok.Error != "" {
+		return nil, fmt.Errorf("oauth: %s: token: %s %s", p.Name, tok.Error, tok.ErrorDescription)
+	}
+	if tok.AccessToken == "" {
+		return nil, fmt.Errorf("oauth: %s: token response without an access token", p.Name)
+	}
+	return &tok, nil
+}
+
+// userInfo fills in the email and name of ident from the userinfo
+// endpoint of an OpenID Connect provider
+func (p *Provider) userInfo(ctx context.Context, accessToken string, ident *Identity) error {
+	var info claims
+	if err := p.getJSON(ctx, p.UserInfoURL, accessToken, &info); err != nil {
+		return err
+	}
+	if info.Subject != ident.Subject {
+		return fmt.Errorf("oauth: %s: userinfo is about another subject", p.Name)
+	}
+	ident.Email, ident.EmailVerified, ident.Name = info.Email, info.EmailVerified.bool(), info.Name
+	return nil
+}
+
+// getJSON decodes the response of a GET request to u into dst,
+// authorized with accessToken unless it is empty
+func (p *Provider) getJSON(ctx context.Context, u, accessToken string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return p.do(req, dst)
+}
+
+// do sends req and decodes the JSON response into dst. Responses
+// that are not a 2xx are an error, but are still decoded.
+func (p *Provider) do(req *http.Request, dst interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("oauth: %s: %w", p.Name, err)
+	}
+	defer resp.Body.Close()
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBody)).Decode(dst)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("oauth: %s: %s returned %s", p.Name, req.URL.Path, resp.Status)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("oauth: %s: decoding %s: %w", p.Name, req.URL.Path, decodeErr)
+	}
+	return nil
+}
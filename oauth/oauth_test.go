@@ -0,0 +1,143 @@
+package oauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/vinny-sabatini/web-dev-with-go/oauth"
+	"github.com/vinny-sabatini/web-dev-with-go/oauth/oauthtest"
+)
+
+const redirectURL = "http://localhost:3000/oauth/test/callback"
+
+// signIn runs the flow against srv up to the callback, returning the
+// state it was started with and the query of the callback
+func signIn(t *testing.T, srv *oauthtest.Server, p *oauth.Provider) (*oauth.State, url.Values) {
+	t.Helper()
+	state, err := oauth.NewState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := srv.Authorize(p.AuthCodeURL(redirectURL, state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state, callback.Query()
+}
+
+func discovered(t *testing.T, srv *oauthtest.Server) *oauth.Provider {
+	t.Helper()
+	p := srv.Provider("test")
+	if err := p.Discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestOIDCFlow(t *testing.T) {
+	srv := oauthtest.NewServer()
+	defer srv.Close()
+	srv.User = oauthtest.User{Subject: "1234", Email: "jon@example.com", EmailVerified: true, Name: "Jon"}
+	p := discovered(t, srv)
+
+	state, query := signIn(t, srv, p)
+	ident, err := p.Callback(context.Background(), redirectURL, state, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := oauth.Identity{Provider: "test", Subject: "1234", Email: "jon@example.com", EmailVerified: true, Name: "Jon"}
+	if *ident != want {
+		t.Errorf("got %+v, want %+v", *ident, want)
+	}
+
+	// Codes can only be exchanged once
+	if _, err := p.Callback(context.Background(), redirectURL, state, query); err == nil {
+		t.Error("a code was exchanged twice")
+	}
+}
+
+func TestCallbackErrors(t *testing.T) {
+	srv := oauthtest.NewServer()
+	defer srv.Close()
+	srv.User = oauthtest.User{Subject: "1234", Email: "jon@example.com"}
+	p := discovered(t, srv)
+	ctx := context.Background()
+
+	state, query := signIn(t, srv, p)
+	other, _ := oauth.NewState()
+	if _, err := p.Callback(ctx, redirectURL, other, query); err != oauth.ErrStateMismatch {
+		t.Errorf("callback with another state: got %v, want %v", err, oauth.ErrStateMismatch)
+	}
+
+	wrongNonce := *state
+	wrongNonce.Nonce = "replayed"
+	if _, err := p.Callback(ctx, redirectURL, &wrongNonce, query); !errors.Is(err, oauth.ErrInvalidIDToken) {
+		t.Errorf("callback with another nonce: got %v, want %v", err, oauth.ErrInvalidIDToken)
+	}
+
+	state, query = signIn(t, srv, p)
+	wrongVerifier := *state
+	wrongVerifier.Verifier = "guessed"
+	if _, err := p.Callback(ctx, redirectURL, &wrongVerifier, query); err == nil {
+		t.Error("a code was exchanged without the PKCE verifier")
+	}
+
+	srv.Deny = true
+	state, query = signIn(t, srv, p)
+	if _, err := p.Callback(ctx, redirectURL, state, query); err != oauth.ErrDenied {
+		t.Errorf("denied sign in: got %v, want %v", err, oauth.ErrDenied)
+	}
+}
+
+func TestIDTokenForAnotherClient(t *testing.T) {
+	srv := oauthtest.NewServer()
+	defer srv.Close()
+	srv.User = oauthtest.User{Subject: "1234", Email: "jon@example.com"}
+	srv.Audience = "other-client"
+	p := discovered(t, srv)
+
+	state, query := signIn(t, srv, p)
+	if _, err := p.Callback(context.Background(), redirectURL, state, query); !errors.Is(err, oauth.ErrInvalidIDToken) {
+		t.Errorf("got %v, want %v", err, oauth.ErrInvalidIDToken)
+	}
+}
+
+func TestGitHubIdentity(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_test", "token_type": "bearer"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gho_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 42, "login": "octocat", "email": "public@example.com"})
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"email": "public@example.com", "primary": false, "verified": true},
+			{"email": "octocat@example.com", "primary": true, "verified": true},
+		})
+	})
+	api := httptest.NewServer(mux)
+	defer api.Close()
+
+	p := oauth.GitHub("id", "secret")
+	p.TokenURL = api.URL + "/login/oauth/access_token"
+	p.UserInfoURL = api.URL + "/user"
+	state, _ := oauth.NewState()
+	query := url.Values{"state": {state.State}, "code": {"abc"}}
+	ident, err := p.Callback(context.Background(), redirectURL, state, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := oauth.Identity{Provider: "github", Subject: "42", Email: "octocat@example.com", EmailVerified: true, Name: "octocat"}
+	if *ident != want {
+		t.Errorf("got %+v, want %+v", *ident, want)
+	}
+}
@@ -0,0 +1,218 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is how far the clocks of a provider and ours may drift
+// apart when checking the expiry of an ID token
+const clockSkew = time.Minute
+
+// OIDC returns a generic OpenID Connect provider for issuer. Call
+// Discover to look up its endpoints before using it.
+func OIDC(name, displayName, issuer, clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         name,
+		DisplayName:  displayName,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+	}
+}
+
+// discovery is the part of an OpenID Connect discovery document we
+// use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Discover looks up the endpoints of the provider in the discovery
+// document of its Issuer
+func (p *Provider) Discover(ctx context.Context) error {
+	var d discovery
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", "", &d); err != nil {
+		return err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer {
+		return fmt.Errorf("oauth: %s: discovery is for issuer %q", p.Name, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return fmt.Errorf("oauth: %s: discovery is missing endpoints", p.Name)
+	}
+	p.AuthURL = d.AuthorizationEndpoint
+	p.TokenURL = d.TokenEndpoint
+	p.UserInfoURL = d.UserInfoEndpoint
+	p.JWKSURL = d.JWKSURI
+	return nil
+}
+
+// claims are the claims of an ID token, or a userinfo response,
+// that we use
+type claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience is the aud claim, which is either a string or a list of
+// strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// flexBool is a boolean claim some providers send as a string
+type flexBool string
+
+func (f *flexBool) UnmarshalJSON(b []byte) error {
+	*f = flexBool(strings.Trim(string(b), `"`))
+	return nil
+}
+
+func (f flexBool) bool() bool {
+	return f == "true"
+}
+
+// verifyIDToken checks the signature and claims of an ID token and
+// returns the identity it is about
+func (p *Provider) verifyIDToken(ctx context.Context, token, nonce string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidIDToken, header.Alg)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	switch {
+	case strings.TrimSuffix(c.Issuer, "/") != p.Issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, c.Issuer)
+	case !c.Audience.contains(p.ClientID):
+		return nil, fmt.Errorf("%w: issued for another client", ErrInvalidIDToken)
+	case time.Unix(c.Expiry, 0).Add(clockSkew).Before(time.Now()):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case c.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case c.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	return &Identity{
+		Subject:       c.Subject,
+		Email:         c.Email,
+		EmailVerified: c.EmailVerified.bool(),
+		Name:          c.Name,
+	}, nil
+}
+
+// decodeSegment decodes a base64 encoded JSON segment of a JWT
+func decodeSegment(seg string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// key returns the signing key with kid. The keys are cached and
+// fetched again when a token is signed with a key we do not know,
+// since providers rotate their keys.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.JWKSURL, "", &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			return nil, fmt.Errorf("oauth: %s: invalid key %q", p.Name, k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+}
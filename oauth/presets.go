@@ -0,0 +1,79 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// GitHub returns a provider for GitHub OAuth apps. GitHub is not an
+// OpenID Connect provider, so users are looked up with its REST API.
+func GitHub(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         "github",
+		DisplayName:  "GitHub",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"read:user", "user:email"},
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserInfoURL:  "https://api.github.com/user",
+		identity:     githubIdentity,
+	}
+}
+
+// Google returns a provider for Google accounts. Its endpoints are
+// known, so it does not need Discover.
+func Google(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         "google",
+		DisplayName:  "Google",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		UserInfoURL:  "https://openidconnect.googleapis.com/v1/userinfo",
+		Issuer:       "https://accounts.google.com",
+		JWKSURL:      "https://www.googleapis.com/oauth2/v3/certs",
+	}
+}
+
+// githubIdentity looks up the GitHub user of accessToken. The email
+// of the profile is the public one, which may be missing or not be
+// verified, so the primary address is taken from /user/emails.
+func githubIdentity(ctx context.Context, p *Provider, accessToken string) (*Identity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.getJSON(ctx, p.UserInfoURL, accessToken, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, fmt.Errorf("oauth: %s: user without an ID", p.Name)
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.getJSON(ctx, p.UserInfoURL+"/emails", accessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	ident := &Identity{
+		Subject: strconv.FormatInt(user.ID, 10),
+		Name:    user.Name,
+	}
+	if ident.Name == "" {
+		ident.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			ident.Email, ident.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return ident, nil
+}
@@ -5,7 +5,10 @@ import (
 	"encoding/base64"
 )
 
-const RememberTokenBytes = 32
+const (
+	RememberTokenBytes = 32
+	APITokenBytes      = 32
+)
 
 // Bytes will help us generate n random bytes, or will
 // return an error if there was one. This uses crypto/rand
@@ -36,3 +39,9 @@ func String(nBytes int) (string, error) {
 func RememberToken() (string, error) {
 	return String(RememberTokenBytes)
 }
+
+// APIToken is a helper function designed to generate personal
+// access tokens of a predetermined byte size.
+func APIToken() (string, error) {
+	return String(APITokenBytes)
+}
@@ -0,0 +1,91 @@
+// Package rbac checks the permissions users are granted by their
+// roles. Admins are granted every permission.
+//
+// Checks are made against the signed in user of a request context.
+// Permissions are looked up once per user and request, and cached
+// for the rest of the request.
+package rbac
+
+import (
+	"context"
+	"sync"
+
+	appctx "github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+)
+
+type privateKey string
+
+const checkerKey privateKey = "checker"
+
+// Policy decides which permissions users have, based on the roles
+// assigned to them
+type Policy struct {
+	Roles models.RoleService
+}
+
+// NewContext returns a copy of ctx that checks permissions with p,
+// with an empty cache. It should be called once per request.
+func (p *Policy) NewContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, checkerKey, &checker{
+		policy: p,
+		cache:  make(map[uint]map[string]bool),
+	})
+}
+
+// checker caches the permissions of every user checked during a
+// request
+type checker struct {
+	policy *Policy
+	mu     sync.Mutex
+	cache  map[uint]map[string]bool
+}
+
+// permissions returns the permissions of user, looking them up the
+// first time they are needed
+func (c *checker) permissions(ctx context.Context, user *models.User) (map[string]bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if perms, ok := c.cache[user.ID]; ok {
+		return perms, nil
+	}
+	list, err := c.policy.Roles.WithContext(ctx).Permissions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	perms := make(map[string]bool, len(list))
+	for _, p := range list {
+		perms[p] = true
+	}
+	c.cache[user.ID] = perms
+	return perms, nil
+}
+
+// Can reports whether the signed in user of ctx has been granted
+// permission. Visitors who are not signed in have no permissions.
+func Can(ctx context.Context, permission string) bool {
+	return UserCan(ctx, appctx.User(ctx), permission)
+}
+
+// UserCan reports whether user has been granted permission. It
+// returns false if ctx was not created by Policy.NewContext, or if
+// the permissions of the user could not be looked up.
+func UserCan(ctx context.Context, user *models.User, permission string) bool {
+	if user == nil {
+		return false
+	}
+	if user.Admin {
+		return true
+	}
+	c, ok := ctx.Value(checkerKey).(*checker)
+	if !ok {
+		return false
+	}
+	perms, err := c.permissions(ctx, user)
+	if err != nil {
+		logging.FromContext(ctx).Error("unable to look up permissions", "user_id", user.ID, "error", err)
+		return false
+	}
+	return perms[permission]
+}
@@ -0,0 +1,64 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	appctx "github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+)
+
+// fakeRoles counts how often the permissions of a user are looked up
+type fakeRoles struct {
+	models.RoleService
+	perms   map[uint][]string
+	lookups int
+}
+
+func (f *fakeRoles) WithContext(ctx context.Context) models.RoleService {
+	return f
+}
+
+func (f *fakeRoles) Permissions(userID uint) ([]string, error) {
+	f.lookups++
+	return f.perms[userID], nil
+}
+
+func TestCan(t *testing.T) {
+	roles := &fakeRoles{perms: map[uint][]string{
+		1: {"users:read"},
+	}}
+	policy := &Policy{Roles: roles}
+	user := &models.User{}
+	user.ID = 1
+	admin := &models.User{Admin: true}
+	admin.ID = 2
+
+	ctx := policy.NewContext(context.Background())
+	if Can(ctx, "users:read") {
+		t.Error("Expected visitors to have no permissions")
+	}
+	userCtx := appctx.WithUser(ctx, user)
+	if !Can(userCtx, "users:read") {
+		t.Error("Expected user to be granted users:read")
+	}
+	if Can(userCtx, "users:write") {
+		t.Error("Expected user not to be granted users:write")
+	}
+	if !UserCan(ctx, admin, "galleries:delete") {
+		t.Error("Expected admins to be granted every permission")
+	}
+	if roles.lookups != 1 {
+		t.Errorf("Expected permissions to be looked up once per request, got %d", roles.lookups)
+	}
+
+	// A new request starts with an empty cache
+	roles.perms[1] = nil
+	userCtx = appctx.WithUser(policy.NewContext(context.Background()), user)
+	if Can(userCtx, "users:read") {
+		t.Error("Expected the permissions of a new request to be looked up again")
+	}
+	if Can(appctx.WithUser(context.Background(), user), "users:read") {
+		t.Error("Expected checks without a policy to be denied")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+)
+
+const rolesUsage = `usage: web-dev-with-go roles <command> [arguments]
+
+commands:
+  list                           list every role and its permissions
+  create <role> [permission...]  create a role
+  delete <role>                  delete a role, taking it away from every user
+  grant <role> <permission...>   add permissions to a role
+  revoke <role> <permission...>  remove permissions from a role
+  assign <email> <role>          give a role to a user
+  unassign <email> <role>        take a role away from a user
+  user <email>                   list the roles and permissions of a user
+`
+
+// errUsage is returned when a command is called with the wrong
+// arguments, after the usage has been written
+var errUsage = errors.New("invalid arguments")
+
+// runRoles manages roles from the command line, eg.
+//
+//	web-dev-with-go roles create moderator users:read
+//	web-dev-with-go roles assign jon@example.com moderator
+func runRoles(services *models.Services, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(out, rolesUsage)
+		return errUsage
+	}
+	rs := services.Role
+	cmd, args := args[0], args[1:]
+	need := func(n int) error {
+		if len(args) < n {
+			fmt.Fprint(out, rolesUsage)
+			return errUsage
+		}
+		return nil
+	}
+
+	switch cmd {
+	case "list":
+		roles, err := rs.All()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tPERMISSIONS\tDESCRIPTION")
+		for _, role := range roles {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", role.Name, role.Permissions, role.Description)
+		}
+		return tw.Flush()
+
+	case "create":
+		if err := need(1); err != nil {
+			return err
+		}
+		role := models.Role{
+			Name:        args[0],
+			Permissions: strings.Join(args[1:], " "),
+		}
+		if err := rs.Create(&role); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "created role %s\n", role.Name)
+
+	case "delete":
+		if err := need(1); err != nil {
+			return err
+		}
+		role, err := rs.ByName(args[0])
+		if err != nil {
+			return fmt.Errorf("role %s: %w", args[0], err)
+		}
+		if err := rs.Delete(role.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "deleted role %s\n", role.Name)
+
+	case "grant", "revoke":
+		if err := need(2); err != nil {
+			return err
+		}
+		role, err := rs.ByName(args[0])
+		if err != nil {
+			return fmt.Errorf("role %s: %w", args[0], err)
+		}
+		perms := role.PermissionList()
+		if cmd == "grant" {
+			perms = append(perms, args[1:]...)
+		} else {
+			perms = without(perms, args[1:])
+		}
+		role.Permissions = strings.Join(perms, " ")
+		if err := rs.Update(role); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "role %s: %s\n", role.Name, role.Permissions)
+
+	case "assign", "unassign":
+		if err := need(2); err != nil {
+			return err
+		}
+		user, err := services.User.ByEmail(args[0])
+		if err != nil {
+			return fmt.Errorf("user %s: %w", args[0], err)
+		}
+		role, err := rs.ByName(args[1])
+		if err != nil {
+			return fmt.Errorf("role %s: %w", args[1], err)
+		}
+		if cmd == "assign" {
+			err = rs.Assign(user.ID, role.ID)
+		} else {
+			err = rs.Unassign(user.ID, role.ID)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%sed role %s for %s\n", cmd, role.Name, user.Email)
+
+	case "user":
+		if err := need(1); err != nil {
+			return err
+		}
+		user, err := services.User.ByEmail(args[0])
+		if err != nil {
+			return fmt.Errorf("user %s: %w", args[0], err)
+		}
+		roles, err := rs.ByUserID(user.ID)
+		if err != nil {
+			return err
+		}
+		perms, err := rs.Permissions(user.ID)
+		if err != nil {
+			return err
+		}
+		names := make([]string, len(roles))
+		for i, role := range roles {
+			names[i] = role.Name
+		}
+		fmt.Fprintf(out, "admin: %t\nroles: %s\npermissions: %s\n", user.Admin, strings.Join(names, " "), strings.Join(perms, " "))
+
+	default:
+		fmt.Fprint(out, rolesUsage)
+		return errUsage
+	}
+	return nil
+}
+
+// without returns the strings of list that are not in remove
+func without(list, remove []string) []string {
+	skip := make(map[string]bool, len(remove))
+	for _, s := range remove {
+		skip[s] = true
+	}
+	var ret []string
+	for _, s := range list {
+		if !skip[s] {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
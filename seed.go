@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/seed"
+)
+
+// runSeed fills the database with demo data, skipping what already
+// exists, eg.
+//
+//	web-dev-with-go seed
+//	web-dev-with-go seed -fixtures testdata/users.json -seed 42
+func runSeed(services *models.Services, out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fs.SetOutput(out)
+	path := fs.String("fixtures", "", "JSON file with the fixtures to create, the built in demo data by default")
+	rndSeed := fs.Int64("seed", 1, "Seed the generated users are picked from")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+
+	fixtures := seed.Demo()
+	if *path != "" {
+		var err error
+		if fixtures, err = seed.LoadFile(*path); err != nil {
+			return err
+		}
+	}
+	s := seed.Seeder{
+		Users:  services.User,
+		Roles:  services.Role,
+		Tokens: services.Token,
+		Seed:   *rndSeed,
+	}
+	res, err := s.Run(fixtures)
+	if res != nil {
+		fmt.Fprintf(out, "created %d roles and %d users, skipped %d that exist\n", len(res.Roles), len(res.Users), len(res.Skipped))
+		for _, warning := range res.Warnings {
+			fmt.Fprintf(out, "warning: %s\n", warning)
+		}
+		for email, tokens := range res.Tokens {
+			for name, token := range tokens {
+				fmt.Fprintf(out, "token %s of %s: %s\n", name, email, token)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,294 @@
+// Package seed fills a database with a repeatable set of users, roles
+// and API tokens for local development. The data is described by
+// fixtures, written as JSON, and created through the model services,
+// so it works on top of any UserDB.
+package seed
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+)
+
+//go:embed fixtures/*.json
+var embedded embed.FS
+
+// Fixtures describe the data to create
+type Fixtures struct {
+	Roles    []Role    `json:"roles"`
+	Users    []User    `json:"users"`
+	Generate *Generate `json:"generate"`
+}
+
+// Role is a role to create
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// User is a user to create, with the roles to give them and the API
+// tokens to create for them
+type User struct {
+	Name     string   `json:"name"`
+	Email    string   `json:"email"`
+	Password string   `json:"password"`
+	Locale   string   `json:"locale"`
+	Admin    bool     `json:"admin"`
+	Disabled bool     `json:"disabled"`
+	Verified bool     `json:"verified"`
+	Roles    []string `json:"roles"`
+	Tokens   []Token  `json:"tokens"`
+}
+
+// Token is an API token to create. A token without a value gets a
+// random one.
+type Token struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Token  string   `json:"token"`
+}
+
+// Generate makes up Count more users, all with the same password.
+// Their names, and whether they are verified or disabled, are picked
+// from the seed of the Seeder.
+type Generate struct {
+	Count    int    `json:"count"`
+	Password string `json:"password"`
+	Domain   string `json:"domain"`
+}
+
+// Load reads fixtures written as JSON
+func Load(r io.Reader) (*Fixtures, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	f := &Fixtures{}
+	if err := dec.Decode(f); err != nil {
+		return nil, fmt.Errorf("seed: invalid fixtures: %w", err)
+	}
+	return f, nil
+}
+
+// LoadFile reads the fixtures in the JSON file at path
+func LoadFile(path string) (*Fixtures, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Load(file)
+}
+
+// Demo returns the fixtures the seed command uses by default: an
+// admin, a moderator and a few dozen generated users, all with the
+// password "password"
+func Demo() *Fixtures {
+	file, err := embedded.Open("fixtures/demo.json")
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	f, err := Load(file)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Seeder creates fixtures with the model services. Users is usually a
+// UserService, so passwords are hashed. Without Roles or Tokens the
+// roles and tokens of the fixtures are skipped with a warning.
+type Seeder struct {
+	Users  models.UserDB
+	Roles  models.RoleService
+	Tokens models.TokenService
+
+	// Seed picks the generated users, the same seed always makes up
+	// the same users
+	Seed int64
+}
+
+// Result lists what a run created and skipped
+type Result struct {
+	Roles   []string
+	Users   []string
+	Skipped []string
+	// Warnings are what could not be created, eg. roles without a
+	// RoleService
+	Warnings []string
+
+	// Tokens are the raw API tokens created, keyed by the email of
+	// their user and then by token name
+	Tokens map[string]map[string]string
+}
+
+// Run creates the roles and users of f. Roles and users that already
+// exist are skipped, so running it again only adds what is missing.
+func (s *Seeder) Run(f *Fixtures) (*Result, error) {
+	users, err := f.users(s.Seed)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Tokens: map[string]map[string]string{}}
+	roles, tokens := f.counts()
+	if s.Roles == nil && roles > 0 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("skipped %d roles, there is no role service", roles))
+	}
+	if s.Tokens == nil && tokens > 0 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("skipped %d tokens, there is no token service", tokens))
+	}
+
+	for _, r := range f.Roles {
+		if s.Roles == nil {
+			break
+		}
+		if _, err := s.Roles.ByName(r.Name); err == nil {
+			res.Skipped = append(res.Skipped, "role "+r.Name)
+			continue
+		} else if err != models.ErrNotFound {
+			return res, err
+		}
+		role := models.Role{
+			Name:        r.Name,
+			Description: r.Description,
+			Permissions: strings.Join(r.Permissions, " "),
+		}
+		if err := s.Roles.Create(&role); err != nil {
+			return res, fmt.Errorf("seed: role %s: %w", r.Name, err)
+		}
+		res.Roles = append(res.Roles, role.Name)
+	}
+
+	for _, u := range users {
+		if _, err := s.Users.ByEmail(u.Email); err == nil {
+			res.Skipped = append(res.Skipped, "user "+u.Email)
+			continue
+		} else if err != models.ErrNotFound {
+			return res, err
+		}
+		if err := s.createUser(res, u); err != nil {
+			return res, fmt.Errorf("seed: user %s: %w", u.Email, err)
+		}
+	}
+	return res, nil
+}
+
+// createUser creates u with their roles and tokens
+func (s *Seeder) createUser(res *Result, u User) error {
+	user := models.User{
+		Name:     u.Name,
+		Email:    u.Email,
+		Password: u.Password,
+		Locale:   u.Locale,
+		Admin:    u.Admin,
+		Disabled: u.Disabled,
+	}
+	if u.Verified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	if err := s.Users.Create(&user); err != nil {
+		return err
+	}
+	res.Users = append(res.Users, user.Email)
+
+	for _, name := range u.Roles {
+		if s.Roles == nil {
+			break
+		}
+		role, err := s.Roles.ByName(name)
+		if err != nil {
+			return fmt.Errorf("role %s: %w", name, err)
+		}
+		if err := s.Roles.Assign(user.ID, role.ID); err != nil {
+			return err
+		}
+	}
+	for _, t := range u.Tokens {
+		if s.Tokens == nil {
+			break
+		}
+		token := models.Token{
+			UserID: user.ID,
+			Name:   t.Name,
+			Scopes: strings.Join(t.Scopes, " "),
+			Token:  t.Token,
+		}
+		if err := s.Tokens.Create(&token); err != nil {
+			return fmt.Errorf("token %s: %w", t.Name, err)
+		}
+		if res.Tokens[user.Email] == nil {
+			res.Tokens[user.Email] = map[string]string{}
+		}
+		res.Tokens[user.Email][token.Name] = token.Token
+	}
+	return nil
+}
+
+// counts returns how many roles f creates and assigns, and how many
+// tokens it creates
+func (f *Fixtures) counts() (roles, tokens int) {
+	roles = len(f.Roles)
+	for _, u := range f.Users {
+		roles += len(u.Roles)
+		tokens += len(u.Tokens)
+	}
+	return roles, tokens
+}
+
+// users returns the users of f followed by the ones it generates
+// from seed. Every user must have an email and a password.
+func (f *Fixtures) users(seed int64) ([]User, error) {
+	users := append([]User(nil), f.Users...)
+	if g := f.Generate; g != nil && g.Count > 0 {
+		domain := g.Domain
+		if domain == "" {
+			domain = "example.com"
+		}
+		rnd := rand.New(rand.NewSource(seed))
+		for i := 1; i <= g.Count; i++ {
+			first := firstNames[rnd.Intn(len(firstNames))]
+			last := lastNames[rnd.Intn(len(lastNames))]
+			users = append(users, User{
+				Name:     first + " " + last,
+				Email:    fmt.Sprintf("%s.%s%d@%s", strings.ToLower(first), strings.ToLower(last), i, domain),
+				Password: g.Password,
+				Verified: rnd.Intn(4) > 0,
+				Disabled: rnd.Intn(10) == 0,
+			})
+		}
+	}
+	for _, u := range users {
+		if u.Email == "" {
+			return nil, fmt.Errorf("seed: user %q has no email", u.Name)
+		}
+		if u.Password == "" {
+			return nil, fmt.Errorf("seed: user %s has no password", u.Email)
+		}
+	}
+	return users, nil
+}
+
+// firstNames and lastNames are combined into the names of generated
+// users. Names are picked by an index into the lists, so changing
+// them in any way, appending included, changes the users of a seed.
+// Leave them as they are.
+var (
+	firstNames = []string{
+		"Ada", "Alan", "Barbara", "Brian", "Claude", "Donald", "Edsger", "Frances",
+		"Grace", "Hedy", "Ivan", "John", "Ken", "Linus", "Margaret", "Niklaus",
+		"Radia", "Rob", "Shafi", "Tim",
+	}
+	lastNames = []string{
+		"Allen", "Backus", "Dijkstra", "Hamilton", "Hopper", "Kay", "Kernighan",
+		"Knuth", "Lamarr", "Liskov", "Lovelace", "McCarthy", "Perlman", "Pike",
+		"Ritchie", "Thompson", "Torvalds", "Turing", "Wirth",
+	}
+)
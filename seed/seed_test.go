@@ -0,0 +1,117 @@
+package seed
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vinny-sabatini/web-dev-with-go/hash"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+)
+
+const testFixtures = `{
+  "users": [
+    {"name": "Ada", "email": "Ada@Example.com", "password": "first-password", "admin": true, "verified": true},
+    {"name": "Alan", "email": "alan@example.com", "password": "second-password", "disabled": true}
+  ],
+  "generate": {"count": 5, "password": "password", "domain": "test.local"}
+}`
+
+func testingSeeder(t *testing.T, seed int64) (*Seeder, models.UserService) {
+	t.Helper()
+	us := models.NewMemoryUserService(hash.NewHMAC("seed-test-secret"), nil)
+	return &Seeder{Users: us, Seed: seed}, us
+}
+
+func testingFixtures(t *testing.T) *Fixtures {
+	t.Helper()
+	f, err := Load(strings.NewReader(testFixtures))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestRunCreatesUsers(t *testing.T) {
+	s, us := testingSeeder(t, 1)
+	res, err := s.Run(testingFixtures(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 7 || len(res.Skipped) != 0 {
+		t.Fatalf("created %d and skipped %d users, want 7 and 0", len(res.Users), len(res.Skipped))
+	}
+
+	user, err := us.Authenticate("ada@example.com", "first-password")
+	if err != nil {
+		t.Fatalf("authenticating a seeded user: %s", err)
+	}
+	if !user.Admin || !user.Verified() {
+		t.Errorf("admin = %t, verified = %t, want both true", user.Admin, user.Verified())
+	}
+	if _, err := us.Authenticate("alan@example.com", "second-password"); err != models.ErrUserDisabled {
+		t.Errorf("authenticating a disabled seeded user: got %v, want %v", err, models.ErrUserDisabled)
+	}
+	for _, email := range res.Users[2:] {
+		if !strings.HasSuffix(email, "@test.local") {
+			t.Errorf("generated user %s is not in the test.local domain", email)
+		}
+	}
+
+	// Running again skips everything that exists
+	res, err = s.Run(testingFixtures(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 0 || len(res.Skipped) != 7 {
+		t.Errorf("second run created %d and skipped %d users, want 0 and 7", len(res.Users), len(res.Skipped))
+	}
+}
+
+func TestGeneratedUsersAreRepeatable(t *testing.T) {
+	run := func(seed int64) []string {
+		s, _ := testingSeeder(t, seed)
+		res, err := s.Run(testingFixtures(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Users
+	}
+	first, again, other := run(7), run(7), run(8)
+	if !reflect.DeepEqual(first, again) {
+		t.Errorf("the same seed made up different users:\n%v\n%v", first, again)
+	}
+	if reflect.DeepEqual(first, other) {
+		t.Errorf("different seeds made up the same users: %v", first)
+	}
+}
+
+func TestDemoFixtures(t *testing.T) {
+	f := Demo()
+	if _, err := f.users(1); err != nil {
+		t.Fatal(err)
+	}
+	// The in-memory backend has no roles, so only the users are created
+	s, _ := testingSeeder(t, 1)
+	res, err := s.Run(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) == 0 || len(res.Roles) != 0 || len(res.Warnings) != 2 {
+		t.Errorf("created %d users and %d roles with warnings %q, want users and a warning for roles and tokens", len(res.Users), len(res.Roles), res.Warnings)
+	}
+}
+
+func TestLoadRejectsInvalidFixtures(t *testing.T) {
+	if _, err := Load(strings.NewReader(`{"users": [{"emial": "typo@example.com"}]}`)); err == nil {
+		t.Error("fixtures with an unknown field were loaded")
+	}
+	f, err := Load(strings.NewReader(`{"users": [{"email": "jon@example.com"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := testingSeeder(t, 1)
+	if _, err := s.Run(f); err == nil {
+		t.Error("a user without a password was seeded")
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/assets"
+	"github.com/vinny-sabatini/web-dev-with-go/controllers"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+	"github.com/vinny-sabatini/web-dev-with-go/metrics"
+	"github.com/vinny-sabatini/web-dev-with-go/middleware"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/rand"
+	"github.com/vinny-sabatini/web-dev-with-go/rbac"
+	"github.com/vinny-sabatini/web-dev-with-go/urls"
+	"github.com/vinny-sabatini/web-dev-with-go/views"
+)
+
+// runServe migrates the database and serves the site until it
+// receives SIGINT or SIGTERM
+func runServe(cfg *config, logger *logging.Logger) error {
+	views.DevMode = cfg.Dev
+	views.BaseURL = cfg.BaseURL
+
+	// Release builds use the templates and assets embedded in the
+	// binary, development reads them from disk so edits show up.
+	assetFS := assets.FS()
+	if cfg.Dev {
+		views.FS = os.DirFS("views")
+		assetFS = os.DirFS("assets/static")
+	}
+	manifest, err := assets.NewManifest(assetFS, "/static/")
+	if err != nil {
+		return err
+	}
+	manifest.Reload = cfg.Dev
+	views.Assets = manifest
+
+	services, err := cfg.openServices(true)
+	if err != nil {
+		return err
+	}
+	defer services.Close()
+
+	metrics.RegisterDBStats(services.DBStats)
+
+	// Routes are registered by name below, the builder looks them up
+	// lazily so it can be handed to controllers right away.
+	r := mux.NewRouter()
+	urlBuilder := urls.NewBuilder(r)
+	views.URLs = urlBuilder
+
+	staticC := controllers.NewStatic()
+	healthC := controllers.NewHealth(
+		controllers.HealthCheck{
+			Name: "database",
+			Check: func(ctx context.Context) error {
+				return services.User.WithContext(ctx).Ping()
+			},
+		},
+		controllers.HealthCheck{
+			Name: "migrations",
+			Check: func(ctx context.Context) error {
+				pending, err := services.PendingMigrations()
+				if err != nil {
+					return err
+				}
+				if pending > 0 {
+					return fmt.Errorf("%d migrations have not been applied", pending)
+				}
+				return nil
+			},
+		},
+		controllers.HealthCheck{
+			Name: "templates",
+			Check: func(ctx context.Context) error {
+				return views.CheckTemplates()
+			},
+		},
+	)
+	// Fail fast if the OpenID Connect provider can not be discovered
+	providers, err := cfg.providers(context.Background())
+	if err != nil {
+		return err
+	}
+	usersC := controllers.NewUsers(services.User, services.Identity, services.Audit, urlBuilder)
+	usersC.DeletionGracePeriod = cfg.DeletionGracePeriod
+	usersC.Providers = providers
+	oauthC := controllers.NewOAuth(services.User, services.Identity, services.Audit, urlBuilder, providers...)
+	oauthC.BaseURL = cfg.BaseURL
+	oauthC.Secure = cfg.secure()
+	tokensC := controllers.NewTokens(services.Token, services.Audit, urlBuilder)
+	accountC := controllers.NewAccount(services.Token, services.Role, services.Identity, services.Audit, urlBuilder)
+	apiC := controllers.NewAPI(services.User, services.Audit)
+	adminC := controllers.NewAdmin(services.User, services.Role, services.Audit, urlBuilder)
+	adminC.DeletionGracePeriod = cfg.DeletionGracePeriod
+	rolesC := controllers.NewRoles(services.Role, services.Audit, urlBuilder)
+
+	userMw := middleware.User{
+		UserService: services.User,
+	}
+	requireUserMw := middleware.RequireUser{
+		URLs: urlBuilder,
+	}
+	permissionsMw := middleware.Permissions{
+		Policy: &rbac.Policy{Roles: services.Role},
+	}
+	canReadUsers := requireUserMw.Require(models.PermUsersRead)
+	canWriteUsers := requireUserMw.Require(models.PermUsersWrite)
+	canManageRoles := requireUserMw.Require(models.PermRolesManage)
+	canReadAudit := requireUserMw.Require(models.PermAuditRead)
+	auditMw := middleware.Audit{}
+	localeMw := middleware.Locale{}
+	logMw := middleware.RequestLogger{
+		Logger: logger,
+	}
+	routeMw := middleware.Route{}
+	recoverMw := middleware.Recover{}
+	metricsMw := middleware.Metrics{}
+	metricsAuthMw := middleware.BasicAuth{
+		Realm: "metrics",
+	}
+	if cfg.MetricsAuth != "" {
+		// loadConfig made sure the colon is there
+		parts := strings.SplitN(cfg.MetricsAuth, ":", 2)
+		metricsAuthMw.Username, metricsAuthMw.Password = parts[0], parts[1]
+	}
+	tokenMw := middleware.Token{
+		TokenService: services.Token,
+		UserService:  services.User,
+	}
+
+	// Record the matched route in the request log and metrics
+	r.Use(func(next http.Handler) http.Handler {
+		return routeMw.Apply(next)
+	})
+	r.Use(func(next http.Handler) http.Handler {
+		return metricsMw.Apply(next)
+	})
+
+	// Health checks
+	r.HandleFunc("/healthz", healthC.Live).Methods("GET").Name("healthz")
+	r.HandleFunc("/readyz", healthC.Ready).Methods("GET").Name("readyz")
+
+	// Static assets
+	r.PathPrefix("/static/").Handler(manifest.Handler()).Name("static")
+
+	// Static controllers
+	r.Handle("/", staticC.Home).Methods("GET").Name("home")
+	r.Handle("/contact", staticC.Contact).Methods("GET").Name("contact")
+	r.NotFoundHandler = metricsMw.Apply(staticC.NotFound.StatusHandler(http.StatusNotFound))
+
+	// User controllers
+	r.HandleFunc("/signup", usersC.New).Methods("GET").Name("signup")
+	r.HandleFunc("/signup", usersC.Create).Methods("POST").Name("signup.create")
+	r.HandleFunc("/login", usersC.LoginPage).Methods("GET").Name("login")
+	r.HandleFunc("/login", usersC.Login).Methods("POST").Name("login.create")
+	r.HandleFunc("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("POST").Name("logout")
+	r.HandleFunc("/oauth/{provider}/login", oauthC.Login).Methods("GET").Name("oauth.login")
+	r.HandleFunc("/oauth/{provider}/callback", oauthC.Callback).Methods("GET").Name("oauth.callback")
+	r.HandleFunc("/cookieTest", usersC.CookieTest).Methods("GET").Name("cookie_test")
+	r.HandleFunc("/locale", usersC.SetLocale).Methods("POST").Name("locale")
+	r.HandleFunc("/account/password/reset", requireUserMw.ApplyFn(usersC.ResetPassword)).Methods("GET").Name("password.reset")
+	r.HandleFunc("/account/password/reset", requireUserMw.ApplyFn(usersC.UpdateResetPassword)).Methods("POST").Name("password.reset.update")
+
+	// Personal access tokens
+	r.HandleFunc("/account/tokens", requireUserMw.ApplyFn(tokensC.Index)).Methods("GET").Name("tokens")
+	r.HandleFunc("/account/tokens", requireUserMw.ApplyFn(tokensC.Create)).Methods("POST").Name("tokens.create")
+	r.HandleFunc("/account/tokens/{id:[0-9]+}/delete", requireUserMw.ApplyFn(tokensC.Delete)).Methods("POST").Name("tokens.delete")
+	r.HandleFunc("/account/settings", requireUserMw.ApplyFn(usersC.Settings)).Methods("GET").Name("account.settings")
+	r.HandleFunc("/account/settings", requireUserMw.ApplyFn(usersC.UpdateSettings)).Methods("POST").Name("account.settings.update")
+	r.HandleFunc("/account/password", requireUserMw.ApplyFn(usersC.ChangePassword)).Methods("POST").Name("account.password.update")
+	r.HandleFunc("/account/delete", requireUserMw.ApplyFn(usersC.DeleteAccount)).Methods("POST").Name("account.delete")
+	r.HandleFunc("/account/identities/{provider}", requireUserMw.ApplyFn(oauthC.Link)).Methods("POST").Name("identities.link")
+	r.HandleFunc("/account/identities/{id:[0-9]+}/delete", requireUserMw.ApplyFn(oauthC.Unlink)).Methods("POST").Name("identities.delete")
+	r.HandleFunc("/account/export", requireUserMw.ApplyFn(accountC.Export)).Methods("GET").Name("account.export")
+	r.HandleFunc("/account/security", requireUserMw.ApplyFn(accountC.Security)).Methods("GET").Name("account.security")
+
+	// Admin console, every route requires a permission
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/users", canReadUsers.ApplyFn(adminC.Users)).Methods("GET").Name("admin.users")
+	admin.HandleFunc("/users/{id:[0-9]+}", canReadUsers.ApplyFn(adminC.User)).Methods("GET").Name("admin.users.show")
+	admin.HandleFunc("/users/{id:[0-9]+}/disable", canWriteUsers.ApplyFn(adminC.DisableUser)).Methods("POST").Name("admin.users.disable")
+	admin.HandleFunc("/users/{id:[0-9]+}/enable", canWriteUsers.ApplyFn(adminC.EnableUser)).Methods("POST").Name("admin.users.enable")
+	admin.HandleFunc("/users/{id:[0-9]+}/reset-password", canWriteUsers.ApplyFn(adminC.ResetUserPassword)).Methods("POST").Name("admin.users.reset_password")
+	admin.HandleFunc("/users/{id:[0-9]+}/sign-out", canWriteUsers.ApplyFn(adminC.SignOutUser)).Methods("POST").Name("admin.users.sign_out")
+	admin.HandleFunc("/users/{id:[0-9]+}/delete", canWriteUsers.ApplyFn(adminC.DeleteUser)).Methods("POST").Name("admin.users.delete")
+	admin.HandleFunc("/users/{id:[0-9]+}/restore", canWriteUsers.ApplyFn(adminC.RestoreUser)).Methods("POST").Name("admin.users.restore")
+	admin.HandleFunc("/users/purge", canWriteUsers.ApplyFn(adminC.PurgeUsers)).Methods("POST").Name("admin.users.purge")
+	admin.HandleFunc("/users/{id:[0-9]+}/roles", canManageRoles.ApplyFn(adminC.AssignRole)).Methods("POST").Name("admin.users.roles")
+	admin.HandleFunc("/users/{id:[0-9]+}/roles/{role_id:[0-9]+}/delete", canManageRoles.ApplyFn(adminC.UnassignRole)).Methods("POST").Name("admin.users.roles.delete")
+	admin.HandleFunc("/roles", canManageRoles.ApplyFn(rolesC.Index)).Methods("GET").Name("admin.roles")
+	admin.HandleFunc("/roles", canManageRoles.ApplyFn(rolesC.Create)).Methods("POST").Name("admin.roles.create")
+	admin.HandleFunc("/roles/{id:[0-9]+}", canManageRoles.ApplyFn(rolesC.Show)).Methods("GET").Name("admin.roles.show")
+	admin.HandleFunc("/roles/{id:[0-9]+}", canManageRoles.ApplyFn(rolesC.Update)).Methods("POST").Name("admin.roles.update")
+	admin.HandleFunc("/roles/{id:[0-9]+}/delete", canManageRoles.ApplyFn(rolesC.Delete)).Methods("POST").Name("admin.roles.delete")
+	admin.HandleFunc("/audit", canReadAudit.ApplyFn(adminC.Audit)).Methods("GET").Name("admin.audit")
+
+	// JSON API
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(func(next http.Handler) http.Handler {
+		return tokenMw.Apply(next)
+	})
+	api.HandleFunc("/users", apiC.CreateUser).Methods("POST").Name("api.users.create")
+	api.HandleFunc("/users/{id:[0-9]+}", apiC.ShowUser).Methods("GET").Name("api.users.show")
+	api.HandleFunc("/sessions", apiC.CreateSession).Methods("POST").Name("api.sessions.create")
+	api.HandleFunc("/sessions", apiC.DeleteSession).Methods("DELETE").Name("api.sessions.delete")
+	api.HandleFunc("/account", apiC.Account).Methods("GET").Name("api.account")
+	api.NotFoundHandler = metricsMw.ApplyFn(apiC.NotFound)
+
+	// Metrics for Prometheus, either on a separate admin address that
+	// is not exposed publicly, or next to the site
+	metricsHandler := metricsAuthMw.Apply(metrics.Handler())
+	if cfg.AdminAddr != "" {
+		admin := http.NewServeMux()
+		admin.Handle("/metrics", metricsHandler)
+		go func() {
+			logger.Error("admin server stopped", "error", http.ListenAndServe(cfg.AdminAddr, admin))
+		}()
+	} else {
+		r.Handle("/metrics", metricsHandler).Methods("GET").Name("metrics")
+	}
+
+	// Fail fast if a template links to a route that does not exist
+	if err := views.CheckRoutes(urlBuilder.Has); err != nil {
+		return err
+	}
+
+	csrfKey := []byte(cfg.CSRFKey)
+	if len(csrfKey) == 0 {
+		logger.Info("no csrf key given, forms opened before a restart will be rejected")
+		if csrfKey, err = rand.Bytes(32); err != nil {
+			return err
+		}
+	}
+	// Without TLS the CSRF cookie can not be secure, or it is never sent
+	csrfMw := csrf.Protect(csrfKey, csrf.Secure(cfg.secure()))
+	skipCSRFMw := middleware.SkipCSRF{
+		Prefix: "/api/",
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: logMw.Apply(recoverMw.Apply(auditMw.Apply(skipCSRFMw.Apply(csrfMw(userMw.Apply(permissionsMw.Apply(localeMw.Apply(r)))))))),
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("server stopped", "error", err)
+			os.Exit(1)
+		}
+	}()
+	logger.Info("server started", "addr", srv.Addr)
+
+	purgeCtx, stopPurging := context.WithCancel(context.Background())
+	defer stopPurging()
+	go purgeDeletedUsers(purgeCtx, services.User, cfg.DeletionGracePeriod, cfg.PurgeInterval)
+
+	// On SIGINT or SIGTERM report not ready first, so load balancers
+	// stop sending new requests, then let open requests finish.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	logger.Info("shutting down")
+	stopPurging()
+	healthC.Shutdown()
+	time.Sleep(cfg.ShutdownDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("unable to shut down cleanly", "error", err)
+	}
+	return nil
+}
+
+// purgeDeletedUsers permanently removes the users deleted longer
+// than gracePeriod ago every interval, until ctx is done
+func purgeDeletedUsers(ctx context.Context, us models.UserService, gracePeriod, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ids, err := us.Purge(time.Now().Add(-gracePeriod))
+		if err != nil {
+			logging.Default().Error("unable to purge deleted users", "error", err)
+		} else if len(ids) > 0 {
+			logging.Default().Info("purged deleted users", "count", len(ids))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
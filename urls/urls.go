@@ -0,0 +1,69 @@
+package urls
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+)
+
+// Builder builds URLs from the names of routes registered on a
+// mux.Router, so paths only need to be spelled out once when the
+// routes are registered.
+type Builder struct {
+	router *mux.Router
+}
+
+// NewBuilder returns a Builder for the routes of router. Routes can
+// still be registered on router after the Builder is created.
+func NewBuilder(router *mux.Router) *Builder {
+	return &Builder{
+		router: router,
+	}
+}
+
+// URL returns the path of the route called name. pairs are the
+// route variables as key/value pairs, eg. URL("tokens.delete", "id", "3")
+func (b *Builder) URL(name string, pairs ...string) (string, error) {
+	route := b.router.Get(name)
+	if route == nil {
+		return "", fmt.Errorf("urls: no route named %q", name)
+	}
+	u, err := route.URL(pairs...)
+	if err != nil {
+		return "", fmt.Errorf("urls: building %q: %w", name, err)
+	}
+	return u.String(), nil
+}
+
+// Has reports whether a route called name is registered
+func (b *Builder) Has(name string) bool {
+	return b.router.Get(name) != nil
+}
+
+// Redirect redirects to the route called name with a 302 Found.
+// If the URL can not be built it is logged and a 500 is sent instead,
+// since that can only be caused by a bug in our code.
+func (b *Builder) Redirect(w http.ResponseWriter, r *http.Request, name string, pairs ...string) {
+	u, err := b.URL(name, pairs...)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to build redirect URL", "route", name, "error", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// RedirectQuery works like Redirect, adding query to the URL of the
+// route, eg. RedirectQuery(w, r, url.Values{"saved": {"profile"}}, "account.settings")
+func (b *Builder) RedirectQuery(w http.ResponseWriter, r *http.Request, query url.Values, name string, pairs ...string) {
+	u, err := b.URL(name, pairs...)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to build redirect URL", "route", name, "error", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, u+"?"+query.Encode(), http.StatusFound)
+}
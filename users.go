@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/rand"
+)
+
+const userUsage = `usage: web-dev-with-go user <command> [arguments]
+
+commands:
+  create [-admin] [-password pw] <email> [name...]
+                                 create a user, reading the password from
+                                 stdin unless -password is given
+  list [-status status] [-search text]
+                                 list the users that are active, disabled or
+                                 deleted, every user that is not deleted by default
+  disable <email>                stop a user from signing in, signing them out
+  enable <email>                 let a disabled user sign in again
+  set-password [-password pw] <email>
+                                 change the password of a user, signing them out
+  deleted                        list the deleted users that have not been purged
+  restore <id>                   restore a deleted user
+  purge [-older-than duration]   permanently remove the users deleted longer ago
+                                 than -older-than, -deletion-grace-period by default
+`
+
+// runUser manages users from the command line, eg.
+//
+//	web-dev-with-go user create -admin jon@example.com Jon Calhoun
+//	web-dev-with-go user disable jon@example.com
+//	web-dev-with-go user restore 42
+func runUser(services *models.Services, in io.Reader, out io.Writer, args []string, gracePeriod time.Duration) error {
+	if len(args) == 0 {
+		fmt.Fprint(out, userUsage)
+		return errUsage
+	}
+	us := services.User
+	cmd, args := args[0], args[1:]
+	stdin := bufio.NewReader(in)
+
+	switch cmd {
+	case "create":
+		fs := flag.NewFlagSet("create", flag.ContinueOnError)
+		fs.SetOutput(out)
+		admin := fs.Bool("admin", false, "Let the user manage other users")
+		password := fs.String("password", "", "Password of the user, read from stdin when empty")
+		if err := fs.Parse(args); err != nil || fs.NArg() == 0 {
+			return errUsage
+		}
+		user := models.User{
+			Email: fs.Arg(0),
+			Name:  strings.Join(fs.Args()[1:], " "),
+			Admin: *admin,
+		}
+		pw, err := readPassword(stdin, out, *password)
+		if err != nil {
+			return err
+		}
+		user.Password = pw
+		if err := us.Create(&user); err != nil {
+			return fmt.Errorf("user %s: %w", fs.Arg(0), err)
+		}
+		fmt.Fprintf(out, "created user %d %s\n", user.ID, user.Email)
+
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ContinueOnError)
+		fs.SetOutput(out)
+		status := fs.String("status", "", "Only list the users that are active, disabled or deleted")
+		search := fs.String("search", "", "Only list the users whose name or email contains this")
+		if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+			return errUsage
+		}
+		q := models.UserQuery{Search: *search, Limit: models.MaxQueryLimit}
+		switch *status {
+		case "":
+		case "active":
+			q.Disabled = boolPtr(false)
+		case "disabled":
+			q.Disabled = boolPtr(true)
+		case "deleted":
+			q.Deleted = true
+		default:
+			return fmt.Errorf("invalid status %q, must be active, disabled or deleted", *status)
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tEMAIL\tNAME\tADMIN\tSTATUS\tCREATED")
+		err := eachUser(us, q, func(u *models.User) {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%t\t%s\t%s\n", u.ID, u.Email, u.Name, u.Admin, userStatus(u), u.CreatedAt.Format(time.RFC3339))
+		})
+		if err != nil {
+			return err
+		}
+		return tw.Flush()
+
+	case "disable", "enable":
+		if len(args) != 1 {
+			fmt.Fprint(out, userUsage)
+			return errUsage
+		}
+		user, err := us.ByEmail(args[0])
+		if err != nil {
+			return fmt.Errorf("user %s: %w", args[0], err)
+		}
+		user.Disabled = cmd == "disable"
+		if user.Disabled {
+			// Signing them out everywhere, like the admin UI does
+			if user.Remember, err = rand.RememberToken(); err != nil {
+				return err
+			}
+		}
+		if err := us.Update(user); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%sd user %s\n", cmd, user.Email)
+
+	case "set-password":
+		fs := flag.NewFlagSet("set-password", flag.ContinueOnError)
+		fs.SetOutput(out)
+		password := fs.String("password", "", "New password, read from stdin when empty")
+		if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+			return errUsage
+		}
+		user, err := us.ByEmail(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("user %s: %w", fs.Arg(0), err)
+		}
+		if user.Password, err = readPassword(stdin, out, *password); err != nil {
+			return err
+		}
+		if user.Remember, err = rand.RememberToken(); err != nil {
+			return err
+		}
+		if err := us.Update(user); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "changed the password of %s\n", user.Email)
+
+	case "deleted":
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tEMAIL\tNAME\tDELETED")
+		q := models.UserQuery{Deleted: true, Limit: models.MaxQueryLimit}
+		err := eachUser(us, q, func(u *models.User) {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", u.ID, u.Email, u.Name, u.DeletedAt.Format(time.RFC3339))
+		})
+		if err != nil {
+			return err
+		}
+		return tw.Flush()
+
+	case "restore":
+		if len(args) != 1 {
+			fmt.Fprint(out, userUsage)
+			return errUsage
+		}
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid user ID %q", args[0])
+		}
+		if err := us.Restore(uint(id)); err != nil {
+			return fmt.Errorf("user %d: %w", id, err)
+		}
+		fmt.Fprintf(out, "restored user %d\n", id)
+
+	case "purge":
+		fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+		fs.SetOutput(out)
+		olderThan := fs.Duration("older-than", gracePeriod, "Purge the users deleted longer ago than this")
+		if err := fs.Parse(args); err != nil {
+			return errUsage
+		}
+		ids, err := us.Purge(time.Now().Add(-*olderThan))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "purged %d users\n", len(ids))
+
+	default:
+		fmt.Fprint(out, userUsage)
+		return errUsage
+	}
+	return nil
+}
+
+// eachUser calls fn with every user matching q, page by page
+func eachUser(us models.UserService, q models.UserQuery, fn func(*models.User)) error {
+	for {
+		page, err := us.Query(q)
+		if err != nil {
+			return err
+		}
+		for i := range page.Users {
+			fn(&page.Users[i])
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		q.After = page.NextCursor
+	}
+}
+
+// userStatus describes whether u can sign in
+func userStatus(u *models.User) string {
+	switch {
+	case u.DeletedAt != nil:
+		return "deleted"
+	case u.Disabled:
+		return "disabled"
+	}
+	return "active"
+}
+
+// readPassword returns password, or reads it from the first line of
+// in when it is empty. An empty password is an error.
+func readPassword(in *bufio.Reader, out io.Writer, password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+	fmt.Fprint(out, "Password: ")
+	line, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	password = strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password is required")
+	}
+	return password, nil
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,9 @@
+package views
+
+import "embed"
+
+// embedded holds every template in the views directory so release
+// builds do not depend on the working directory they are run from.
+//
+//go:embed */*.gohtml */*.txt
+var embedded embed.FS
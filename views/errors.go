@@ -0,0 +1,75 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
+)
+
+var (
+	errorView     *View
+	errorViewOnce sync.Once
+)
+
+// ErrorData is the data rendered by the error page
+type ErrorData struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// MarshalJSON wraps the error in the same envelope the JSON API
+// uses, eg. {"error": {"status": 404, ...}}
+func (e ErrorData) MarshalJSON() ([]byte, error) {
+	type apiError struct {
+		Status  int    `json:"status"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	return json.Marshal(map[string]apiError{"error": apiError(e)})
+}
+
+// Error renders the styled error page for the provided HTTP status.
+// The page is negotiated like any other view, so API clients receive
+// a JSON error envelope. If the error page itself can not be rendered
+// we fall back to a plain text http.Error.
+func Error(w http.ResponseWriter, r *http.Request, status int) {
+	errorViewOnce.Do(func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Printf("views: unable to parse error page: %v", rec)
+			}
+		}()
+		errorView = NewView("bootstrap", "errors/error").WithMeta(Meta{NoIndex: true})
+	})
+	text := http.StatusText(status)
+	if errorView == nil {
+		http.Error(w, text, status)
+		return
+	}
+	data := ErrorData{
+		Status:  status,
+		Code:    strings.ToLower(strings.ReplaceAll(text, " ", "_")),
+		Message: text,
+	}
+	if l := context.Localizer(r.Context()); l.Has(fmt.Sprintf("errors.status.%d", status)) {
+		data.Message = l.T(fmt.Sprintf("errors.status.%d", status))
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	contentType, err := errorView.execute(buf, r, data)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("unable to render error page", "error", err)
+		http.Error(w, text, status)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
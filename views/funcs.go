@@ -0,0 +1,282 @@
+package views
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"path"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/csrf"
+	"github.com/vinny-sabatini/web-dev-with-go/context"
+	"github.com/vinny-sabatini/web-dev-with-go/i18n"
+	"github.com/vinny-sabatini/web-dev-with-go/models"
+	"github.com/vinny-sabatini/web-dev-with-go/rbac"
+	"github.com/yuin/goldmark"
+)
+
+// DefaultDateLayout is used by the date function when no layout is given
+const DefaultDateLayout = "Jan 2, 2006"
+
+// AssetResolver looks up the fingerprinted URL and subresource
+// integrity hash of a static asset, eg. an *assets.Manifest
+type AssetResolver interface {
+	URL(name string) (string, error)
+	Integrity(name string) (string, error)
+}
+
+// URLResolver builds URLs from route names, eg. a *urls.Builder
+type URLResolver interface {
+	URL(name string, pairs ...string) (string, error)
+}
+
+var (
+	// Assets is used by the asset template functions. When it is nil
+	// (eg. in tests) assets resolve to their plain /static/ path.
+	Assets AssetResolver
+
+	// URLs is used by urlFor to build URLs from named routes
+	URLs URLResolver
+
+	// extraFuncs are registered by controllers and tests with AddFuncs
+	extraFuncs = template.FuncMap{}
+)
+
+// AddFuncs registers extra functions available to every view
+// created afterwards. Functions with the same name as a built in
+// function replace it.
+func AddFuncs(funcMap template.FuncMap) {
+	for name, fn := range funcMap {
+		extraFuncs[name] = fn
+	}
+}
+
+// funcs returns the functions available to every template. Functions
+// that depend on the current request are placeholders here, they are
+// replaced by requestFuncs every time a view is rendered.
+func funcs() template.FuncMap {
+	ret := template.FuncMap{
+		"asset":          asset,
+		"assetIntegrity": assetIntegrity,
+		"date":           date,
+		"timeAgo":        timeAgo,
+		"pluralize":      pluralize,
+		"truncate":       truncate,
+		"markdown":       markdown,
+		"urlFor":         urlFor,
+		"locales":        i18n.Locales,
+		"siteName":       func() string { return SiteName },
+		"t": func(key string, args ...interface{}) (string, error) {
+			return "", errors.New("views: t called outside of a request")
+		},
+		"locale": func() (string, error) {
+			return "", errors.New("views: locale called outside of a request")
+		},
+		"meta": func() (pageMeta, error) {
+			return pageMeta{}, errors.New("views: meta called outside of a request")
+		},
+		"isActive": func(section string) (bool, error) {
+			return false, errors.New("views: isActive called outside of a request")
+		},
+		"currentUser": func() (*models.User, error) {
+			return nil, errors.New("views: currentUser called outside of a request")
+		},
+		"can": func(permission string) (bool, error) {
+			return false, errors.New("views: can called outside of a request")
+		},
+		"signedIn": func() (bool, error) {
+			return false, errors.New("views: signedIn called outside of a request")
+		},
+		"csrfField": func() (template.HTML, error) {
+			return "", errors.New("views: csrfField called outside of a request")
+		},
+	}
+	for name, fn := range extraFuncs {
+		ret[name] = fn
+	}
+	return ret
+}
+
+// requestFuncs returns the functions bound to the view and request
+// being rendered
+func requestFuncs(v *View, r *http.Request) template.FuncMap {
+	l := context.Localizer(r.Context())
+	return template.FuncMap{
+		"t": l.T,
+		"locale": func() string {
+			return l.Locale
+		},
+		"meta": func() pageMeta {
+			return newPageMeta(v, r)
+		},
+		// isActive reports whether the current page is in section,
+		// eg. {{if isActive "tokens"}}active{{end}}
+		"isActive": func(s string) bool {
+			return inSection(r, s)
+		},
+		"currentUser": func() *models.User {
+			return context.User(r.Context())
+		},
+		// can reports whether the signed in user has been granted
+		// permission, eg. {{if can "users:read"}}...{{end}}
+		"can": func(permission string) bool {
+			return rbac.Can(r.Context(), permission)
+		},
+		"signedIn": func() bool {
+			return context.User(r.Context()) != nil
+		},
+		"csrfField": func() template.HTML {
+			return csrf.TemplateField(r)
+		},
+	}
+}
+
+// asset resolves the fingerprinted URL of a static asset
+//
+// Eg. {{asset "css/app.css"}} would result in "/static/css/app.0123456789ab.css"
+func asset(name string) (string, error) {
+	if Assets == nil {
+		return path.Join("/static", name), nil
+	}
+	return Assets.URL(name)
+}
+
+// assetIntegrity resolves the subresource integrity hash of a static asset
+//
+// Eg. {{assetIntegrity "css/app.css"}} would result in "sha384-..."
+func assetIntegrity(name string) (string, error) {
+	if Assets == nil {
+		return "", nil
+	}
+	return Assets.Integrity(name)
+}
+
+// urlFor builds the URL of a named route, pairs are the
+// route variables as key/value pairs
+//
+// Eg. {{urlFor "tokens.delete" "id" .ID}} would result in "/account/tokens/3/delete"
+func urlFor(name string, pairs ...interface{}) (string, error) {
+	if URLs == nil {
+		return "", errors.New("views: no URLs configured for urlFor")
+	}
+	vars := make([]string, len(pairs))
+	for i, p := range pairs {
+		vars[i] = fmt.Sprint(p)
+	}
+	return URLs.URL(name, vars...)
+}
+
+// date formats a time.Time (or *time.Time) using layout, which
+// defaults to DefaultDateLayout. Nil times result in an empty string.
+//
+// Eg. {{date .CreatedAt}} or {{date .CreatedAt "2006-01-02 15:04"}}
+func date(t interface{}, layout ...string) (string, error) {
+	tm, ok, err := toTime(t)
+	if err != nil || !ok {
+		return "", err
+	}
+	l := DefaultDateLayout
+	if len(layout) > 0 {
+		l = layout[0]
+	}
+	return tm.Format(l), nil
+}
+
+// timeAgo describes a time.Time (or *time.Time) relative to now
+//
+// Eg. "just now", "5 minutes ago" or "in 3 days"
+func timeAgo(t interface{}) (string, error) {
+	tm, ok, err := toTime(t)
+	if err != nil || !ok {
+		return "", err
+	}
+	return relativeTime(tm, time.Now()), nil
+}
+
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+	if d < time.Minute {
+		return "just now"
+	}
+	var amount string
+	switch {
+	case d < time.Hour:
+		amount = pluralize(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		amount = pluralize(int(d/time.Hour), "hour")
+	case d < 30*24*time.Hour:
+		amount = pluralize(int(math.Round(d.Hours()/24)), "day")
+	case d < 365*24*time.Hour:
+		amount = pluralize(int(math.Round(d.Hours()/24/30)), "month")
+	default:
+		amount = pluralize(int(math.Round(d.Hours()/24/365)), "year")
+	}
+	if future {
+		return "in " + amount
+	}
+	return amount + " ago"
+}
+
+func toTime(t interface{}) (time.Time, bool, error) {
+	switch v := t.(type) {
+	case time.Time:
+		return v, !v.IsZero(), nil
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, false, nil
+		}
+		return *v, !v.IsZero(), nil
+	case nil:
+		return time.Time{}, false, nil
+	default:
+		return time.Time{}, false, fmt.Errorf("views: expected a time, got %T", t)
+	}
+}
+
+// pluralize returns count followed by the singular or plural form
+// of a word. The plural defaults to the singular with an "s" added.
+//
+// Eg. {{pluralize 3 "token"}} would result in "3 tokens"
+func pluralize(count int, singular string, plural ...string) string {
+	word := singular
+	if count != 1 {
+		word = singular + "s"
+		if len(plural) > 0 {
+			word = plural[0]
+		}
+	}
+	return fmt.Sprintf("%d %s", count, word)
+}
+
+// truncate shortens s to at most n characters, adding an ellipsis
+// if anything was removed. It takes s last so it can be piped.
+//
+// Eg. {{.Description | truncate 100}}
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n < 1 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
+
+// markdown renders s as markdown. Raw HTML and dangerous links like
+// javascript: URLs are stripped, so the result is safe to output.
+func markdown(s string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(s), &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
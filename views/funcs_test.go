@@ -0,0 +1,67 @@
+package views
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2021, 11, 20, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		t    time.Time
+		want string
+	}{
+		{now.Add(-10 * time.Second), "just now"},
+		{now.Add(-1 * time.Minute), "1 minute ago"},
+		{now.Add(-5 * time.Hour), "5 hours ago"},
+		{now.Add(3 * 24 * time.Hour), "in 3 days"},
+		{now.AddDate(-2, 0, 0), "2 years ago"},
+	}
+	for _, c := range cases {
+		if got := relativeTime(c.t, now); got != c.want {
+			t.Errorf("relativeTime(%s) = %q, want %q", c.t, got, c.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate(10, "short"); got != "short" {
+		t.Errorf("Expected short strings to be left alone, got %q", got)
+	}
+	if got := truncate(5, "Grüße aus Detroit"); got != "Grüß…" {
+		t.Errorf("Expected %q, got %q", "Grüß…", got)
+	}
+}
+
+func TestMarkdownIsSafe(t *testing.T) {
+	html, err := markdown("**hi** <script>alert(1)</script> [x](javascript:alert(1))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), "<strong>hi</strong>") {
+		t.Errorf("Expected markdown to be rendered, got %s", html)
+	}
+	if strings.Contains(string(html), "<script>") || strings.Contains(string(html), "javascript:") {
+		t.Errorf("Expected unsafe HTML to be removed, got %s", html)
+	}
+}
+
+func TestAddFuncs(t *testing.T) {
+	AddFuncs(template.FuncMap{
+		"shout": strings.ToUpper,
+	})
+	defer delete(extraFuncs, "shout")
+
+	tpl := template.Must(template.New("").Funcs(funcs()).Parse(`{{define "bootstrap"}}{{shout .}} {{pluralize 2 "goal"}}{{end}}`))
+	v := &View{Template: tpl, Layout: "bootstrap"}
+	w := httptest.NewRecorder()
+	if err := v.Render(w, httptest.NewRequest("GET", "/", nil), "go wings"); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Body.String(); got != "GO WINGS 2 goals" {
+		t.Fatalf("Expected %q, got %q", "GO WINGS 2 goals", got)
+	}
+}
@@ -0,0 +1,28 @@
+package views
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/vinny-sabatini/web-dev-with-go/i18n"
+)
+
+func TestTemplatesUseKnownMessages(t *testing.T) {
+	pages, err := fs.Glob(embedded, "*/*"+TemplateExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := i18n.New(i18n.DefaultLocale)
+	for _, page := range pages {
+		if strings.HasPrefix(page, LayoutDir) {
+			continue
+		}
+		v := NewView("bootstrap", strings.TrimSuffix(page, TemplateExtension))
+		for _, key := range v.literalArgs("t") {
+			if !l.Has(key) {
+				t.Errorf("%s: unknown message key %q", page, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package views
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// BaseURL is the public URL of the site, eg. "https://example.com".
+// It is used to build absolute canonical and Open Graph URLs, which
+// are left out when it is empty.
+var BaseURL string
+
+// SiteName is shown in every page title and as the Open Graph site name
+var SiteName = "Vinny Sabatini"
+
+// Meta is the metadata of a page. Title and Description are i18n
+// message keys, though plain strings also work since unknown keys
+// are rendered as is.
+type Meta struct {
+	Title       string
+	Description string
+	// Image is the name of a static asset shown when the page is shared
+	Image string
+	// Type is the Open Graph type, defaulting to "website"
+	Type string
+	// NoIndex asks search engines not to index the page
+	NoIndex bool
+}
+
+// WithMeta sets the metadata of the view and returns it, so it can be
+// chained with NewView
+//
+// Eg. views.NewView("bootstrap", "static/home").WithMeta(views.Meta{Title: "meta.home.title"})
+func (v *View) WithMeta(meta Meta) *View {
+	v.Meta = meta
+	return v
+}
+
+// pageMeta is what the layouts see when they call the meta function
+type pageMeta struct {
+	Meta
+	Canonical string
+	ImageURL  string
+}
+
+func newPageMeta(v *View, r *http.Request) pageMeta {
+	pm := pageMeta{
+		Meta: v.Meta,
+	}
+	if pm.Type == "" {
+		pm.Type = "website"
+	}
+	if BaseURL != "" {
+		pm.Canonical = strings.TrimSuffix(BaseURL, "/") + r.URL.EscapedPath()
+		if pm.Image != "" {
+			if u, err := asset(pm.Image); err == nil {
+				pm.ImageURL = strings.TrimSuffix(BaseURL, "/") + u
+			}
+		}
+	}
+	return pm
+}
+
+// inSection reports whether the request belongs to a section of the
+// site, which is the name of the matched route or a prefix of it
+//
+// Eg. a request to the "admin.users.show" route is in both the
+// "admin" and "admin.users" sections
+func inSection(r *http.Request, section string) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	name := route.GetName()
+	return name == section || strings.HasPrefix(name, section+".")
+}
@@ -0,0 +1,30 @@
+package views
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMetaAndActiveSection(t *testing.T) {
+	defer func(base string) { BaseURL = base }(BaseURL)
+	BaseURL = "https://example.com/"
+
+	tpl := template.Must(template.New("").Funcs(funcs()).Parse(
+		`{{define "bootstrap"}}{{meta.Title}} {{meta.Canonical}} {{isActive "tokens"}} {{isActive "home"}}{{end}}`))
+	v := (&View{Template: tpl, Layout: "bootstrap"}).WithMeta(Meta{Title: "meta.tokens.title"})
+
+	r := mux.NewRouter()
+	r.Handle("/tokens/new", v).Name("tokens.create")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tokens/new", nil))
+
+	want := "meta.tokens.title https://example.com/tokens/new true false"
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
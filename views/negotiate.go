@@ -0,0 +1,92 @@
+package views
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const (
+	mimeHTML = "text/html"
+	mimeJSON = "application/json"
+	mimeText = "text/plain"
+)
+
+// acceptRange is a single media range from an Accept header,
+// eg. "text/*;q=0.8"
+type acceptRange struct {
+	typ     string
+	subtype string
+	q       float64
+}
+
+// negotiate picks the content type from offers that best matches
+// the provided Accept header. Offers are listed in order of server
+// preference, which is used to break ties. If the header is empty
+// or nothing in it is acceptable the first offer is returned.
+func negotiate(accept string, offers ...string) string {
+	if accept == "" {
+		return offers[0]
+	}
+	ranges := parseAccept(accept)
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		if q := quality(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// quality returns the q value the most specific matching
+// media range assigns to offer, or 0 if nothing matches.
+func quality(ranges []acceptRange, offer string) float64 {
+	typ, subtype := splitType(offer)
+	q, specificity := 0.0, -1
+	for _, ar := range ranges {
+		s := -1
+		switch {
+		case ar.typ == typ && ar.subtype == subtype:
+			s = 2
+		case ar.typ == typ && ar.subtype == "*":
+			s = 1
+		case ar.typ == "*" && ar.subtype == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = ar.q, s
+		}
+	}
+	return q
+}
+
+// parseAccept parses an Accept header into its media ranges,
+// skipping any that are malformed
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		ar := acceptRange{q: 1}
+		ar.typ, ar.subtype = splitType(mediaType)
+		if v, ok := params["q"]; ok {
+			q, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			ar.q = q
+		}
+		ranges = append(ranges, ar)
+	}
+	return ranges
+}
+
+func splitType(mediaType string) (string, string) {
+	i := strings.Index(mediaType, "/")
+	if i < 0 {
+		return mediaType, "*"
+	}
+	return mediaType[:i], mediaType[i+1:]
+}
@@ -0,0 +1,27 @@
+package views
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{mimeHTML, mimeJSON, mimeText}
+	cases := []struct {
+		accept string
+		want   string
+	}{
+		{"", mimeHTML},
+		{"*/*", mimeHTML},
+		{"application/json", mimeJSON},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", mimeHTML},
+		{"application/json, text/plain;q=0.5", mimeJSON},
+		{"text/*;q=0.5, application/json;q=0.4", mimeHTML},
+		{"text/plain, */*;q=0.1", mimeText},
+		{"text/html;q=0, */*", mimeJSON},
+		{"image/png", mimeHTML},
+		{"not a type, application/json", mimeJSON},
+	}
+	for _, c := range cases {
+		if got := negotiate(c.accept, offers...); got != c.want {
+			t.Errorf("negotiate(%q) = %q, want %q", c.accept, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package views
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"time"
+)
+
+// reload re-parses the templates of the view if any of its files
+// (or the set of layout files) changed since they were last parsed.
+// The last parse error is returned until the files are fixed.
+func (v *View) reload() error {
+	v.mu.RLock()
+	changed := v.changed()
+	err := v.err
+	v.mu.RUnlock()
+	if !changed {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.changed() {
+		return v.err
+	}
+	v.err = v.parse()
+	if v.err != nil {
+		// Remember the files we failed on so we only retry
+		// once they are modified again.
+		v.modTimes, _ = modTimes(v.files())
+		log.Printf("views: %v", v.err)
+	}
+	return v.err
+}
+
+// changed reports whether any file of the view was modified, added
+// or removed since the templates were parsed
+func (v *View) changed() bool {
+	files := v.files()
+	if len(files) != len(v.modTimes) {
+		return true
+	}
+	for _, f := range files {
+		info, err := fs.Stat(FS, f)
+		if err != nil {
+			return true
+		}
+		if last, ok := v.modTimes[f]; !ok || !info.ModTime().Equal(last) {
+			return true
+		}
+	}
+	return false
+}
+
+// files returns every file the view is currently made of
+func (v *View) files() []string {
+	files := append(templateFiles(v.pages), layoutFiles()...)
+	return append(files, textTemplateFiles(v.pages)...)
+}
+
+// modTimes returns the modification time of each of the provided files
+func modTimes(files []string) (map[string]time.Time, error) {
+	ret := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		info, err := fs.Stat(FS, f)
+		if err != nil {
+			return nil, err
+		}
+		ret[f] = info.ModTime()
+	}
+	return ret, nil
+}
+
+var devErrorTemplate = template.Must(template.New("devError").Parse(`<!DOCTYPE html>
+<html lang="en">
+    <head>
+        <title>Template Error</title>
+    </head>
+    <body style="font-family: sans-serif; margin: 2em;">
+        <h1 style="color: #b02a37;">Template Error</h1>
+        <p>The templates for this page could not be parsed. Fix the error below and refresh the page.</p>
+        <pre style="background: #f8d7da; padding: 1em; white-space: pre-wrap;">{{.}}</pre>
+    </body>
+</html>`))
+
+// renderDevError shows a template parse error in the browser.
+// It is only used in DevMode, since it leaks file paths.
+func renderDevError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	if execErr := devErrorTemplate.Execute(w, err.Error()); execErr != nil {
+		fmt.Fprintln(w, err)
+	}
+}
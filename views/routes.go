@@ -0,0 +1,148 @@
+package views
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template/parse"
+)
+
+var (
+	registryMu sync.Mutex
+	registry   []*View
+)
+
+// register keeps track of every view so they can be checked at startup
+func register(v *View) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, v)
+}
+
+// CheckRoutes looks through the templates of every view created so
+// far for urlFor calls and returns an error listing the route names
+// has returns false for. It is meant to be called at startup, once
+// all routes are registered.
+func CheckRoutes(has func(name string) bool) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	unknown := make(map[string]bool)
+	for _, v := range registry {
+		v.mu.RLock()
+		for _, name := range v.routeNames() {
+			if !has(name) {
+				unknown[name] = true
+			}
+		}
+		v.mu.RUnlock()
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(unknown))
+	for name := range unknown {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("views: templates refer to unknown routes %q", names)
+}
+
+// CheckTemplates returns an error if the templates of any view
+// created so far failed to parse. Outside of DevMode NewView panics
+// instead, so this only fails while templates are being edited.
+func CheckTemplates() error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	var failed []string
+	for _, v := range registry {
+		v.mu.RLock()
+		if v.err != nil {
+			failed = append(failed, v.err.Error())
+		}
+		v.mu.RUnlock()
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("views: %d views failed to parse: %s", len(failed), strings.Join(failed, "; "))
+}
+
+// routeNames returns the names of every route the view's templates
+// pass to urlFor as a string literal
+func (v *View) routeNames() []string {
+	return v.literalArgs("urlFor")
+}
+
+// literalArgs returns the first argument of every call to the
+// function fn in the view's templates, when it is a string literal
+//
+// Eg. {{urlFor "home"}} would result in {"home"} for fn "urlFor"
+func (v *View) literalArgs(fn string) []string {
+	var args []string
+	if v.Template != nil {
+		for _, t := range v.Template.Templates() {
+			if t.Tree != nil {
+				args = append(args, literalArgsIn(t.Tree.Root, fn)...)
+			}
+		}
+	}
+	if v.Text != nil {
+		for _, t := range v.Text.Templates() {
+			if t.Tree != nil {
+				args = append(args, literalArgsIn(t.Tree.Root, fn)...)
+			}
+		}
+	}
+	return args
+}
+
+// literalArgsIn walks a template parse tree collecting the first
+// argument of every call to fn
+func literalArgsIn(node parse.Node, fn string) []string {
+	var names []string
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			names = append(names, literalArgsIn(child, fn)...)
+		}
+	case *parse.ActionNode:
+		names = append(names, literalArgsIn(n.Pipe, fn)...)
+	case *parse.IfNode:
+		names = append(names, literalArgsInBranch(&n.BranchNode, fn)...)
+	case *parse.RangeNode:
+		names = append(names, literalArgsInBranch(&n.BranchNode, fn)...)
+	case *parse.WithNode:
+		names = append(names, literalArgsInBranch(&n.BranchNode, fn)...)
+	case *parse.TemplateNode:
+		names = append(names, literalArgsIn(n.Pipe, fn)...)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			names = append(names, literalArgsIn(cmd, fn)...)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) > 1 {
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == fn {
+				if s, ok := n.Args[1].(*parse.StringNode); ok {
+					names = append(names, s.Text)
+				}
+			}
+		}
+		for _, arg := range n.Args {
+			names = append(names, literalArgsIn(arg, fn)...)
+		}
+	}
+	return names
+}
+
+func literalArgsInBranch(n *parse.BranchNode, fn string) []string {
+	names := literalArgsIn(n.Pipe, fn)
+	names = append(names, literalArgsIn(n.List, fn)...)
+	return append(names, literalArgsIn(n.ElseList, fn)...)
+}
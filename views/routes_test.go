@@ -0,0 +1,25 @@
+package views
+
+import (
+	"html/template"
+	"reflect"
+	"testing"
+)
+
+func TestRouteNames(t *testing.T) {
+	tpl := template.Must(template.New("").Funcs(funcs()).Parse(`
+		{{define "bootstrap"}}
+			<a href="{{urlFor "home"}}">Home</a>
+			{{if signedIn}}
+				{{range .}}<a href="{{urlFor "tokens.delete" "id" .}}">x</a>{{end}}
+			{{else}}
+				<a href="{{(urlFor "login")}}">Login</a>
+			{{end}}
+		{{end}}`))
+	v := &View{Template: tpl, Layout: "bootstrap"}
+	got := v.routeNames()
+	want := []string{"home", "tokens.delete", "login"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected route names %v, got %v", want, got)
+	}
+}
@@ -1,67 +1,234 @@
 package views
 
 import (
+	"bytes"
+	"encoding/json"
 	"html/template"
+	"io/fs"
+	"log"
 	"net/http"
-	"path/filepath"
+	"path"
+	"sync"
+	textTemplate "text/template"
+	"time"
+
+	"github.com/vinny-sabatini/web-dev-with-go/logging"
 )
 
 var (
-	LayoutDir         string = "views/layouts/"
-	TemplateDirectory string = "views/"
-	TemplateExtension string = ".gohtml"
+	LayoutDir             string = "layouts/"
+	TemplateDirectory     string = ""
+	TemplateExtension     string = ".gohtml"
+	TextTemplateExtension string = ".txt"
 )
 
+// FS is the filesystem templates are loaded from, the directories
+// above are relative to it. It defaults to the templates embedded in
+// the binary, development builds can use os.DirFS("views") instead so
+// changes on disk are picked up. It should be set before any views
+// are created.
+var FS fs.FS = embedded
+
+// DevMode enables template hot reloading. Views check their files
+// for changes before every render and parse errors are shown in the
+// browser instead of crashing the server. It should be set before
+// any views are created.
+var DevMode bool
+
 func NewView(layout string, files ...string) *View {
 	addTemplatePath(files)
-	addTemplateExt(files)
-	files = append(files, layoutFiles()...)
-	t, err := template.ParseFiles(files...)
-	if err != nil {
+	v := &View{
+		Layout: layout,
+		pages:  files,
+	}
+	if err := v.parse(); err != nil {
+		if DevMode {
+			log.Printf("views: %v", err)
+			v.err = err
+			v.modTimes, _ = modTimes(v.files())
+			register(v)
+			return v
+		}
 		// We are panicing here because this is only being used when the application is starting,
 		// there is not a good way to recover, the app should not start when pages are missing
 		panic(err)
 	}
-	return &View{
-		Template: t,
-		Layout:   layout,
-	}
+	register(v)
+	return v
 }
 
 type View struct {
 	Template *template.Template
 	Layout   string
+
+	// Text is an optional plain text variant of the view, it is
+	// only set when a matching TextTemplateExtension file exists
+	Text *textTemplate.Template
+
+	// Meta is the page title, description etc. used by the layouts
+	Meta Meta
+
+	// pages are the template files of this view without their
+	// extension, eg. "users/new"
+	pages []string
+
+	// mu guards the templates while they are reloaded in DevMode
+	mu       sync.RWMutex
+	modTimes map[string]time.Time
+	err      error
+}
+
+// parse parses the page and layout templates of the view,
+// remembering when each file was last modified
+func (v *View) parse() error {
+	text := textTemplateFiles(v.pages)
+	files := append(templateFiles(v.pages), layoutFiles()...)
+	modTimes, err := modTimes(append(files, text...))
+	if err != nil {
+		return err
+	}
+	t, err := template.New(path.Base(files[0])).Funcs(funcs()).ParseFS(FS, files...)
+	if err != nil {
+		return err
+	}
+	var tt *textTemplate.Template
+	if len(text) > 0 {
+		tt, err = textTemplate.New(path.Base(text[0])).Funcs(textTemplate.FuncMap(funcs())).ParseFS(FS, text...)
+		if err != nil {
+			return err
+		}
+	}
+	v.Template, v.Text, v.modTimes = t, tt, modTimes
+	return nil
 }
 
+// ServeHTTP renders the view without any data. Errors are
+// already handled by Render, so there is nothing left to do here.
 func (v *View) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	err := v.Render(w, nil)
+	v.Render(w, r, nil)
+}
+
+// StatusHandler returns a handler that renders the view without
+// any data using the provided HTTP status, eg. for a 404 page.
+func (v *View) StatusHandler(status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v.RenderStatus(w, r, status, nil)
+	})
+}
+
+// Render is used to render the view with a 200 OK status.
+// See RenderStatus for details.
+func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	return v.RenderStatus(w, r, http.StatusOK, data)
+}
+
+// RenderStatus is used to render the view in the format requested by
+// the Accept header of r. Browsers get the HTML page with the predefined
+// layout, API clients asking for JSON get data encoded as JSON, and
+// text/plain is available when the view has a text template.
+//
+// The view is rendered into a buffer first and only written out once
+// it succeeded. If rendering fails the cause is logged, an error page
+// with a 500 status is sent instead, and the error is returned.
+func (v *View) RenderStatus(w http.ResponseWriter, r *http.Request, status int, data interface{}) error {
+	if DevMode {
+		if err := v.reload(); err != nil {
+			renderDevError(w, err)
+			return err
+		}
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	w.Header().Add("Vary", "Accept")
+	contentType, err := v.execute(buf, r, data)
 	if err != nil {
-		panic(err)
+		logging.FromContext(r.Context()).Error("unable to render view", "error", err)
+		Error(w, r, http.StatusInternalServerError)
+		return err
 	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return err
 }
 
-// Render is used to render the view with the predefined layoued.
-func (v *View) Render(w http.ResponseWriter, data interface{}) error {
-	w.Header().Set("Content-Type", "text/html")
-	return v.Template.ExecuteTemplate(w, v.Layout, data)
+// execute renders the variant of the view negotiated for r into buf
+// and returns the content type of what was rendered
+func (v *View) execute(buf *bytes.Buffer, r *http.Request, data interface{}) (string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	switch negotiate(r.Header.Get("Accept"), v.offers()...) {
+	case mimeJSON:
+		return "application/json", json.NewEncoder(buf).Encode(data)
+	case mimeText:
+		t, err := v.Text.Clone()
+		if err != nil {
+			return "", err
+		}
+		t.Funcs(textTemplate.FuncMap(requestFuncs(v, r)))
+		return "text/plain; charset=utf-8", t.Execute(buf, data)
+	default:
+		// The request specific functions are added to a clone, since
+		// html/template does not allow changing them once executed.
+		t, err := v.Template.Clone()
+		if err != nil {
+			return "", err
+		}
+		t.Funcs(requestFuncs(v, r))
+		return "text/html; charset=utf-8", t.ExecuteTemplate(buf, v.Layout, data)
+	}
+}
+
+// offers returns the content types this view is able to render,
+// in order of preference
+func (v *View) offers() []string {
+	if v.Text != nil {
+		return []string{mimeHTML, mimeJSON, mimeText}
+	}
+	return []string{mimeHTML, mimeJSON}
 }
 
 // layoutFiles returns a slice of strings representing the layout files used in our app
 func layoutFiles() []string {
-	files, err := filepath.Glob(LayoutDir + "*" + TemplateExtension)
+	files, err := fs.Glob(FS, LayoutDir+"*"+TemplateExtension)
 	if err != nil {
 		panic(err)
 	}
 	return files
 }
 
+// textTemplateFiles returns the plain text variants that exist
+// for the provided template files
+//
+// Eg. input {"static/contact"} would result in {"static/contact.txt"}
+// if that file exists and TextTemplateExtension == ".txt"
+func textTemplateFiles(files []string) []string {
+	var ret []string
+	for _, f := range files {
+		name := f + TextTemplateExtension
+		if _, err := fs.Stat(FS, name); err == nil {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// templateFiles returns a copy of pages with the
+// TemplateExtension appended to each of them
+func templateFiles(pages []string) []string {
+	files := make([]string, len(pages))
+	copy(files, pages)
+	addTemplateExt(files)
+	return files
+}
+
 // addTemplatePath takes in a slice of strings
 // representing file paths for templates and
 // it prepends the TemplateDirectory to each
 // string in the slice
 //
-// Eg. input {"home"} would result in {"views/home"}
-// if TemplateDirectory == "views/"
+// Eg. input {"home"} would result in {"static/home"}
+// if TemplateDirectory == "static/"
 func addTemplatePath(files []string) {
 	for i, f := range files {
 		files[i] = TemplateDirectory + f
@@ -0,0 +1,27 @@
+package views
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderBuffersFailedTemplates(t *testing.T) {
+	v := &View{
+		Template: template.Must(template.New("").Parse(`{{define "bootstrap"}}partial page {{index . 5}}{{end}}`)),
+		Layout:   "bootstrap",
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := v.Render(w, r, []string{}); err == nil {
+		t.Fatal("Expected an error rendering the view, got nil")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "partial page") {
+		t.Fatalf("Expected the partial page to be discarded, got %q", w.Body.String())
+	}
+}